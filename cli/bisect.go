@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BisectResult records a bisect session: the commits measured against the
+// good revision and the first one whose metric regressed beyond the
+// threshold.
+type BisectResult struct {
+	Good      string       `json:"good"`
+	Bad       string       `json:"bad"`
+	Metric    string       `json:"metric"`
+	Threshold float64      `json:"threshold"`
+	Steps     []BisectStep `json:"steps"`
+	// FirstBad is empty when bad didn't regress beyond the threshold
+	FirstBad string `json:"first_bad,omitempty"`
+}
+
+// BisectStep is a commit measured by bisect, Change being the relative
+// difference of the metric with the good revision.
+type BisectStep struct {
+	Commit    string  `json:"commit"`
+	Subject   string  `json:"subject"`
+	Change    float64 `json:"change"`
+	PValue    float64 `json:"p_value"`
+	Regressed bool    `json:"regressed"`
+}
+
+// commit is a commit of the bisected range.
+type commit struct {
+	Hash    string
+	Subject string
+}
+
+// higherIsBetter lists the metrics for which an increase is an improvement.
+var higherIsBetter = map[string]bool{"ipc": true}
+
+// regression returns how much worse change makes metric, negative when it
+// improved.
+func regression(metric string, change float64) float64 {
+	if higherIsBetter[metric] {
+		return -change
+	}
+	return change
+}
+
+// parseThreshold reads a relative threshold such as "5%" or "5".
+func parseThreshold(value string) (float64, error) {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "%"), 64)
+	if err != nil || percent <= 0 {
+		return 0, fmt.Errorf("invalid threshold %q, use a positive percentage like 5%%", value)
+	}
+	return percent / 100, nil
+}
+
+// bisectCommits lists the first-parent commits after good up to bad that
+// changed folder, oldest first. Other commits don't change what is measured.
+func bisectCommits(folder, good, bad string) ([]commit, error) {
+	output, err := exec.Command("git", "-C", folder, "log", "--first-parent", "--reverse", "--format=%h %s", good+".."+bad, "--", ".").Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("git log failed: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+
+	var commits []commit
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line == "" {
+			continue
+		}
+		hash, subject, _ := strings.Cut(line, " ")
+		commits = append(commits, commit{Hash: hash, Subject: subject})
+	}
+	if len(commits) == 0 {
+		return nil, fmt.Errorf("no commit between %s and %s changes %s", good, bad, folder)
+	}
+	return commits, nil
+}
+
+// bisectSession measures commits of folder against the good revision, all of
+// them on the same machine.
+type bisectSession struct {
+	remote       *remoteHost
+	stagedFolder string
+	folder       string
+	opts         runScriptOptions
+	good         string
+	metric       string
+	threshold    float64
+	result       *BenchmarkResult
+}
+
+// run searches commits for the first one regressing the metric, starting
+// with the last one to check that the range regressed at all. It returns
+// false if a benchmark failed.
+func (s *bisectSession) run(commits []commit) bool {
+	bisect := s.result.Bisect
+	infoLog("Bisecting %d commits after %s on %s (threshold %s)", len(commits), s.good, s.metric, formatChange(s.threshold))
+
+	if err := s.prepare(); err != nil {
+		errorLog("Bisect failed: %v", err)
+		return false
+	}
+	last, err := s.measure(commits[len(commits)-1])
+	if err != nil {
+		errorLog("Bisect failed: %v", err)
+		return false
+	}
+	if !last.Regressed {
+		infoLog("%s did not regress %s beyond %s (%s)", bisect.Bad, s.metric, formatChange(s.threshold), formatChange(last.Change))
+		return true
+	}
+
+	// good is known not to regress and the last commit to regress
+	lo, hi := -1, len(commits)-1
+	for hi-lo > 1 {
+		infoLog("Bisecting: %d commit(s) left to test", hi-lo-1)
+		mid := (lo + hi) / 2
+		step, err := s.measure(commits[mid])
+		if err != nil {
+			errorLog("Bisect failed: %v", err)
+			return false
+		}
+		if step.Regressed {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+
+	first := commits[hi]
+	bisect.FirstBad = first.Hash
+	for _, step := range bisect.Steps {
+		if step.Commit == first.Hash {
+			successLog("First commit regressing %s beyond %s: %s %s (%s, p=%s)", s.metric, formatChange(s.threshold), first.Hash, first.Subject, formatChange(step.Change), formatPValue(step.PValue))
+		}
+	}
+	return true
+}
+
+// prepare installs the runtimes and perf and runs the setup hooks once for the
+// session, so that every step only runs the measured part of the script.
+func (s *bisectSession) prepare() error {
+	if len(s.opts.Runtimes) == 0 && s.opts.Perf == "" && len(s.opts.Setup) == 0 {
+		return nil
+	}
+	script := buildPrepareScript(s.opts)
+	if err := os.WriteFile(filepath.Join(s.stagedFolder, prepareScriptName), []byte(script), 0755); err != nil {
+		return err
+	}
+	startSpinner("Copying files to remote machine...")
+	err := s.remote.Upload(s.stagedFolder, remoteBenchmarkDir)
+	stopSpinner()
+	if err != nil {
+		return fmt.Errorf("failed to copy files to remote machine: %v", err)
+	}
+
+	infoLog("Preparing the machine for every step...")
+	output, err := s.remote.Run("bash " + remoteBenchmarkDir + "/" + prepareScriptName)
+	if !reportSections(parseSections(output)) {
+		return errors.New("preparing the machine failed")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to prepare the remote machine: %v %s", err, strings.TrimSpace(redact(string(output))))
+	}
+	s.opts.Prepared = true
+	return nil
+}
+
+// measure benchmarks c interleaved with the good revision and records the
+// change of the metric as a bisect step.
+func (s *bisectSession) measure(c commit) (BisectStep, error) {
+	infoLog("Measuring %s %s against %s", c.Hash, c.Subject, s.good)
+	if err := os.RemoveAll(filepath.Join(s.stagedFolder, revisionsDir)); err != nil {
+		return BisectStep{}, err
+	}
+	revisions, err := exportRevisions(s.folder, []string{s.good, c.Hash}, s.stagedFolder)
+	if err != nil {
+		return BisectStep{}, err
+	}
+
+	opts := s.opts
+	opts.Variants = nil
+	for _, rev := range revisions {
+		opts.Variants = append(opts.Variants, scriptVariant{Name: rev.Name, Command: opts.Command, Dir: rev.Dir})
+	}
+	script := buildRunScript(opts)
+	if err := os.WriteFile(filepath.Join(s.stagedFolder, runScriptName), []byte(script), 0755); err != nil {
+		return BisectStep{}, err
+	}
+
+	// Only the revisions of the previous step are replaced, the rest of the
+	// benchmark folder is left as the upload finds it
+	if output, err := s.remote.Run("rm -rf " + remoteBenchmarkDir + "/" + revisionsDir); err != nil {
+		return BisectStep{}, fmt.Errorf("failed to remove the previous revisions: %v %s", err, strings.TrimSpace(string(output)))
+	}
+	measured := &BenchmarkResult{StartedAt: time.Now(), System: s.result.System}
+	if !runBenchmark(s.remote, s.stagedFolder, measured) {
+		if measured.Interrupted {
+			s.result.Interrupted = true
+			s.result.Interruptions = append(s.result.Interruptions, measured.Interruptions...)
+		}
+		return BisectStep{}, fmt.Errorf("benchmark of %s failed", c.Hash)
+	}
+	s.result.System = measured.System
+
+	for _, comparison := range measured.Comparisons {
+		if comparison.Metric != s.metric {
+			continue
+		}
+		step := BisectStep{
+			Commit:    c.Hash,
+			Subject:   c.Subject,
+			Change:    comparison.Change,
+			PValue:    comparison.PValue,
+			Regressed: regression(s.metric, comparison.Change) > s.threshold,
+		}
+		s.result.Bisect.Steps = append(s.result.Bisect.Steps, step)
+		if step.Regressed {
+			errorLog("%s regressed %s by %s", c.Hash, s.metric, formatChange(step.Change))
+		} else {
+			successLog("%s is good (%s %s)", c.Hash, s.metric, formatChange(step.Change))
+		}
+		return step, nil
+	}
+	return BisectStep{}, fmt.Errorf("%s was not measured", s.metric)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// resultsDir is the local folder collected artifacts are downloaded to, one
+// subfolder per run.
+const resultsDir = "results"
+
+// collectPatternChars restricts --collect to plain paths and glob syntax, as
+// patterns are expanded by the remote shell.
+var collectPatternChars = regexp.MustCompile(`^[A-Za-z0-9_.,@+=:%/*?\[\]{}-]+$`)
+
+// validateCollectPatterns makes sure every --collect glob stays inside the
+// remote benchmark directory.
+func validateCollectPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if !collectPatternChars.MatchString(pattern) {
+			return fmt.Errorf("invalid pattern %q: only paths and glob characters are allowed", pattern)
+		}
+		if filepath.IsAbs(pattern) || pattern == ".." || strings.HasPrefix(pattern, "../") || strings.Contains(pattern, "/../") {
+			return fmt.Errorf("invalid pattern %q: must be relative to the benchmark folder", pattern)
+		}
+	}
+	return nil
+}
+
+// collectArtifacts downloads the files matching patterns, relative to
+// remoteDir, into localDir. The remote side streams them as a tar archive so
+// the folder structure is preserved. It returns the paths that were written.
+func collectArtifacts(remote *remoteHost, remoteDir string, patterns []string, localDir string) ([]string, error) {
+	command := "cd " + remoteDir + " && shopt -s globstar nullglob dotglob && " +
+		"for f in " + strings.Join(patterns, " ") + `; do [ -f "$f" ] && printf '%s\0' "$f"; done | ` +
+		"tar -cf - --null --no-recursion -T -"
+
+	cmd := remote.command(command)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &strings.Builder{}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	written, extractErr := extractTar(stdout, localDir)
+	// Drain what's left so ssh can exit
+	io.Copy(io.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		return written, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return written, extractErr
+}
+
+// extractTar writes the files, directories and symlinks of the archive below
+// dir, refusing any entry or link target that would escape it.
+func extractTar(r io.Reader, dir string) ([]string, error) {
+	var written []string
+	archive := tar.NewReader(r)
+	for {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			return written, nil
+		}
+		if err != nil {
+			return written, err
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeDir && header.Typeflag != tar.TypeSymlink {
+			continue
+		}
+
+		name := filepath.FromSlash(strings.TrimPrefix(header.Name, "./"))
+		if name == "" || name == "." {
+			continue
+		}
+		if !filepath.IsLocal(name) {
+			return written, fmt.Errorf("refusing to extract %q outside of %s", header.Name, dir)
+		}
+		// A link extracted earlier could redirect the entry outside of dir
+		if err := checkNoSymlinkParent(dir, name); err != nil {
+			return written, err
+		}
+		dst := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return written, err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dst, os.FileMode(header.Mode).Perm()|0700); err != nil {
+				return written, err
+			}
+			continue
+		case tar.TypeSymlink:
+			target := filepath.FromSlash(header.Linkname)
+			if filepath.IsAbs(target) || !filepath.IsLocal(filepath.Join(filepath.Dir(name), target)) {
+				return written, fmt.Errorf("refusing to link %q to %q outside of %s", header.Name, header.Linkname, dir)
+			}
+			if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return written, err
+			}
+			if err := os.Symlink(header.Linkname, dst); err != nil {
+				return written, err
+			}
+		default:
+			file, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode).Perm()|0600)
+			if err != nil {
+				return written, err
+			}
+			_, err = io.Copy(file, archive)
+			file.Close()
+			if err != nil {
+				return written, err
+			}
+		}
+		written = append(written, dst)
+	}
+}
+
+// checkNoSymlinkParent makes sure none of the folders leading to name below
+// dir is a symlink.
+func checkNoSymlinkParent(dir, name string) error {
+	parent := dir
+	parts := strings.Split(filepath.Dir(name), string(filepath.Separator))
+	for _, part := range parts {
+		if part == "." {
+			continue
+		}
+		parent = filepath.Join(parent, part)
+		info, err := os.Lstat(parent)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to extract %q through the symlink %s", name, parent)
+		}
+	}
+	return nil
+}
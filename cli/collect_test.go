@@ -0,0 +1,73 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tarArchive(t *testing.T, headers ...*tar.Header) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := tar.NewWriter(&buf)
+	for _, header := range headers {
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(header.Name))
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			archive.Write([]byte(header.Name))
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestExtractTarKeepsSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	archive := tarArchive(t,
+		&tar.Header{Name: "lib/", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "lib/data.txt", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "current", Typeflag: tar.TypeSymlink, Linkname: "lib/data.txt"},
+		&tar.Header{Name: "lib/self", Typeflag: tar.TypeSymlink, Linkname: "../lib"},
+	)
+	if _, err := extractTar(archive, dir); err != nil {
+		t.Fatal(err)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "current")); err != nil || target != "lib/data.txt" {
+		t.Errorf("current links to %q (%v), want lib/data.txt", target, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "current")); err != nil || string(data) != "lib/data.txt" {
+		t.Errorf("reading through current = %q (%v)", data, err)
+	}
+}
+
+func TestExtractTarRejectsEscapes(t *testing.T) {
+	tests := map[string][]*tar.Header{
+		"parent path":   {{Name: "../evil", Typeflag: tar.TypeReg}},
+		"absolute link": {{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"}},
+		"relative link": {{Name: "sub/link", Typeflag: tar.TypeSymlink, Linkname: "../../outside"}},
+		"through link": {
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "."},
+			{Name: "link/file", Typeflag: tar.TypeReg},
+		},
+	}
+	for name, headers := range tests {
+		t.Run(name, func(t *testing.T) {
+			parent := t.TempDir()
+			dir := filepath.Join(parent, "dest")
+			if _, err := extractTar(tarArchive(t, headers...), dir); err == nil {
+				t.Errorf("extractTar() succeeded, want an error")
+			}
+			if _, err := os.Lstat(filepath.Join(parent, "outside")); err == nil {
+				t.Errorf("extractTar() wrote outside of %s", dir)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// Names of the variants measured with --baseline-command/--baseline-folder.
+const (
+	baselineVariant  = "baseline"
+	candidateVariant = "candidate"
+)
+
+// baselineDir holds the --baseline-folder copy in the staged folder.
+const baselineDir = ".ib-baseline"
+
+// MetricComparison is the difference of a metric between the runs of a
+// candidate variant and those of the baseline.
+type MetricComparison struct {
+	Metric        string  `json:"metric"`
+	Baseline      string  `json:"baseline"`
+	Candidate     string  `json:"candidate"`
+	BaselineMean  float64 `json:"baseline_mean"`
+	CandidateMean float64 `json:"candidate_mean"`
+	// Change is relative to the baseline mean, -0.05 being 5% lower
+	Change float64 `json:"change"`
+	// PValue comes from the Mann-Whitney U test, WelchPValue from Welch's
+	// t-test, both two-sided
+	PValue      float64 `json:"p_value"`
+	WelchPValue float64 `json:"welch_p_value"`
+	Significant bool    `json:"significant"`
+}
+
+// compareVariants compares every metric measured by all runs of baseline and
+// of each candidate.
+func compareVariants(runs []RunResult, baseline string, candidates []string) []MetricComparison {
+	var comparisons []MetricComparison
+	for _, candidate := range candidates {
+		comparisons = append(comparisons, compareRuns(runsOf(runs, baseline), runsOf(runs, candidate), baseline, candidate)...)
+	}
+	return comparisons
+}
+
+// compareRuns compares every metric measured by all of the baseline and
+// candidate runs. Metrics with a zero baseline mean have no relative
+// difference and are left out.
+func compareRuns(baselineRuns, candidateRuns []RunResult, baseline, candidate string) []MetricComparison {
+	var comparisons []MetricComparison
+	for _, metric := range runMetrics {
+		a, okA := metricValues(baselineRuns, metric)
+		b, okB := metricValues(candidateRuns, metric)
+		if !okA || !okB || mean(a) == 0 {
+			continue
+		}
+		comparison := MetricComparison{
+			Metric:        metric.name,
+			Baseline:      baseline,
+			Candidate:     candidate,
+			BaselineMean:  mean(a),
+			CandidateMean: mean(b),
+			PValue:        mannWhitneyU(a, b),
+			WelchPValue:   welchTTest(a, b),
+		}
+		comparison.Change = (comparison.CandidateMean - comparison.BaselineMean) / comparison.BaselineMean
+		comparison.Significant = comparison.PValue < significanceLevel
+		comparisons = append(comparisons, comparison)
+	}
+	return comparisons
+}
+
+// metricValues returns the value of metric for every run, or false if one of
+// them didn't measure it.
+func metricValues(runs []RunResult, metric runMetric) ([]float64, bool) {
+	if len(runs) == 0 {
+		return nil, false
+	}
+	values := make([]float64, len(runs))
+	for i, run := range runs {
+		if !metric.available(run) {
+			return nil, false
+		}
+		values[i] = metric.value(run)
+	}
+	return values, true
+}
+
+// printComparisons prints a table per candidate and a verdict on its wall
+// time.
+func printComparisons(comparisons []MetricComparison) {
+	var candidates []string
+	for _, comparison := range comparisons {
+		if !contains(candidates, comparison.Candidate) {
+			candidates = append(candidates, comparison.Candidate)
+		}
+	}
+
+	for _, candidate := range candidates {
+		var wall *MetricComparison
+		var rows [][]string
+		for i, comparison := range comparisons {
+			if comparison.Candidate != candidate {
+				continue
+			}
+			metric, _ := findMetric(comparison.Metric)
+			if rows == nil {
+				rows = [][]string{{"Metric", comparison.Baseline, candidate, "Change", "p (Mann-Whitney)", "p (Welch)", "Significant"}}
+			}
+			rows = append(rows, []string{
+				metric.header,
+				metric.format(comparison.BaselineMean),
+				metric.format(comparison.CandidateMean),
+				formatChange(comparison.Change),
+				formatPValue(comparison.PValue),
+				formatPValue(comparison.WelchPValue),
+				yesNo(comparison.Significant),
+			})
+			if comparison.Metric == "wall" {
+				wall = &comparisons[i]
+			}
+		}
+		printTable(rows)
+
+		if wall == nil {
+			continue
+		}
+		if wall.Significant {
+			direction := "faster"
+			if wall.Change > 0 {
+				direction = "slower"
+			}
+			successLog("%s is %.1f%% %s than %s (p=%s)", candidate, 100*math.Abs(wall.Change), direction, wall.Baseline, formatPValue(wall.PValue))
+		} else {
+			infoLog("No significant wall time difference between %s and %s (%s, p=%s)", candidate, wall.Baseline, formatChange(wall.Change), formatPValue(wall.PValue))
+		}
+	}
+}
+
+func formatChange(change float64) string {
+	return fmt.Sprintf("%+.1f%%", 100*change)
+}
+
+func formatPValue(p float64) string {
+	if p < 0.001 {
+		return "<0.001"
+	}
+	return strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.3f", p), "0"), ".")
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
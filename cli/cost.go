@@ -0,0 +1,219 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultPrices are the hourly on-demand prices the estimates start from,
+// overridden by ~/.ib/prices.json when it exists.
+//
+//go:embed prices.json
+var defaultPrices []byte
+
+// awsRegion is the region of the AWS Terraform module.
+const awsRegion = "us-east-1"
+
+// provisioningOverhead is the time a machine lives besides the benchmark:
+// provisioning, runtime installation, upload and destroy.
+const provisioningOverhead = 5 * time.Minute
+
+// unmeasuredRunTime is the assumed duration of the benchmark commands when
+// no earlier run of the same command is in the history.
+const unmeasuredRunTime = time.Minute
+
+// priceTable holds the hourly price of machine types, per provider and
+// region or location.
+type priceTable struct {
+	// Currency applies to the providers without their own
+	Currency  string                    `json:"currency,omitempty"`
+	Updated   string                    `json:"updated"`
+	Providers map[string]providerPrices `json:"providers"`
+}
+
+type providerPrices struct {
+	Currency string `json:"currency,omitempty"`
+	// Billing is "second", or "hour" when every started hour is billed
+	Billing        string                        `json:"billing"`
+	MinimumSeconds int                           `json:"minimum_seconds,omitempty"`
+	Regions        map[string]map[string]float64 `json:"regions"`
+}
+
+// CostResult is the cost of the machines provisioned for a benchmark.
+type CostResult struct {
+	Currency  string  `json:"currency"`
+	Estimated float64 `json:"estimated"`
+	Actual    float64 `json:"actual,omitempty"`
+	// Machines holds the actual cost of each machine of a --matrix run
+	Machines map[string]float64 `json:"machines,omitempty"`
+	// Unpriced lists the machines missing from the price table
+	Unpriced []string `json:"unpriced,omitempty"`
+}
+
+// loadPrices reads the price table from ~/.ib/prices.json, or the embedded
+// one.
+func loadPrices() (*priceTable, error) {
+	data := defaultPrices
+	if home, err := os.UserHomeDir(); err == nil {
+		path := filepath.Join(home, ".ib", "prices.json")
+		if fileExists(path) {
+			if data, err = os.ReadFile(path); err != nil {
+				return nil, err
+			}
+			debugLog("Using prices from %s", path)
+		}
+	}
+	var prices priceTable
+	if err := json.Unmarshal(data, &prices); err != nil {
+		return nil, fmt.Errorf("invalid price table: %v", err)
+	}
+	return &prices, nil
+}
+
+// machineType returns the instance or server type of spec.
+func machineType(spec machineSpec) string {
+	return valueOr(spec.Vars["instance_type"], spec.Vars["server_type"])
+}
+
+// machineRegion returns the region or location whose prices apply to spec.
+func machineRegion(spec machineSpec) string {
+	if spec.Cloud == "aws" {
+		return awsRegion
+	}
+	return spec.Vars["location"]
+}
+
+// cost returns the price of running spec for duration, following the billing
+// of its provider, or false when its type isn't in the table.
+func (t *priceTable) cost(spec machineSpec, duration time.Duration) (float64, bool) {
+	provider, ok := t.Providers[spec.Cloud]
+	if !ok {
+		return 0, false
+	}
+	hourly, ok := provider.Regions[machineRegion(spec)][machineType(spec)]
+	// A spot instance costs at most its maximum price, at most the on-demand
+	// price otherwise
+	if maxPrice, err := strconv.ParseFloat(spec.Vars["spot_max_price"], 64); err == nil {
+		hourly, ok = maxPrice, true
+	}
+	if !ok {
+		return 0, false
+	}
+	if provider.Billing == "hour" {
+		return hourly * math.Max(1, math.Ceil(duration.Hours())), true
+	}
+	seconds := math.Max(float64(provider.MinimumSeconds), math.Ceil(duration.Seconds()))
+	return hourly * seconds / 3600, true
+}
+
+// currency returns the currency of the prices of cloud.
+func (t *priceTable) currency(cloud string) string {
+	return valueOr(t.Providers[cloud].Currency, t.Currency)
+}
+
+func formatCost(currency string, amount float64) string {
+	if currency == "USD" {
+		return fmt.Sprintf("$%.4f", amount)
+	}
+	return fmt.Sprintf("%.4f %s", amount, currency)
+}
+
+// costLine describes cost, the actual one being known once the machines are
+// destroyed.
+func costLine(cost *CostResult) string {
+	line := "estimated " + formatCost(cost.Currency, cost.Estimated)
+	if cost.Actual > 0 {
+		line = formatCost(cost.Currency, cost.Actual) + " (" + line + ")"
+	}
+	return line
+}
+
+// expectedRuntime estimates how long a machine lives for runs of command,
+// warmups included, from the last successful run of the same command in the
+// history. It also describes what the estimate is based on.
+func expectedRuntime(command string, runs int) (time.Duration, string) {
+	results, _ := loadHistory()
+	for i := len(results) - 1; i >= 0; i-- {
+		result := results[i]
+		if result.Failed || result.Command != command || len(result.Runs) == 0 {
+			continue
+		}
+		total := 0.0
+		for _, run := range result.Runs {
+			total += run.Wall
+		}
+		measured := time.Duration(total / float64(len(result.Runs)) * float64(runs) * float64(time.Second))
+		return provisioningOverhead + measured, fmt.Sprintf("%v of provisioning and the runs of %s", provisioningOverhead, result.ID)
+	}
+	return provisioningOverhead + unmeasuredRunTime, fmt.Sprintf("%v of provisioning and %v of runs, without an earlier run of the command", provisioningOverhead, unmeasuredRunTime)
+}
+
+// estimateCost prints the estimated cost of running specs for duration and
+// returns it. Prices in different currencies are refused, they can't be added
+// up.
+func estimateCost(prices *priceTable, specs []machineSpec, duration time.Duration, basis string) (*CostResult, error) {
+	estimate := &CostResult{}
+	for _, spec := range specs {
+		currency := prices.currency(spec.Cloud)
+		if estimate.Currency == "" {
+			estimate.Currency = currency
+		} else if currency != estimate.Currency {
+			return nil, fmt.Errorf("the prices of %s are in %s and others in %s, use a single currency in ~/.ib/prices.json", spec.Cloud, currency, estimate.Currency)
+		}
+	}
+	for _, spec := range specs {
+		cost, ok := prices.cost(spec, duration)
+		if !ok {
+			estimate.Unpriced = append(estimate.Unpriced, spec.Cloud+" "+machineType(spec))
+			infoLog("No price known for %s %s in %s, update ~/.ib/prices.json", spec.Cloud, machineType(spec), machineRegion(spec))
+			continue
+		}
+		estimate.Estimated += cost
+		if len(specs) > 1 {
+			infoLog("  %-24s %s", spec.Name, formatCost(estimate.Currency, cost))
+		}
+	}
+	infoLog("Estimated cost: %s for %s per machine (%s)", formatCost(estimate.Currency, estimate.Estimated), duration.Round(time.Second), basis)
+	return estimate, nil
+}
+
+// parseCost reads an amount such as "5", "0.50" or "$2".
+func parseCost(value string) (float64, error) {
+	amount, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(value), "$"), 64)
+	if err != nil || amount < 0 {
+		return 0, fmt.Errorf("%q is not an amount", value)
+	}
+	return amount, nil
+}
+
+// checkBudget refuses an estimate above maxCost, or one with unpriced machines,
+// unless the user confirmed with --yes. Without a budget, maxCost is nil.
+func checkBudget(estimate *CostResult, maxCost *float64, confirmed bool) error {
+	if confirmed || maxCost == nil {
+		return nil
+	}
+	if len(estimate.Unpriced) > 0 {
+		return fmt.Errorf("the cost of %s is unknown, use --yes to proceed anyway", strings.Join(estimate.Unpriced, ", "))
+	}
+	if estimate.Estimated > *maxCost {
+		return fmt.Errorf("the estimated cost of %s exceeds the budget of %s, use --yes to proceed anyway",
+			formatCost(estimate.Currency, estimate.Estimated), formatCost(estimate.Currency, *maxCost))
+	}
+	return nil
+}
+
+// machine returns the actual cost of a machine of a --matrix run.
+func (c *CostResult) machine(name string) (float64, bool) {
+	if c == nil {
+		return 0, false
+	}
+	cost, ok := c.Machines[name]
+	return cost, ok
+}
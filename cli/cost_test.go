@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestEstimateCostCurrencies(t *testing.T) {
+	var prices priceTable
+	if err := json.Unmarshal(defaultPrices, &prices); err != nil {
+		t.Fatal(err)
+	}
+	specs := []machineSpec{
+		{Name: "aws", Cloud: "aws", Vars: map[string]string{"instance_type": "c6i.large"}},
+		{Name: "hetzner", Cloud: "hetzner", Vars: map[string]string{"server_type": "cx22", "location": "fsn1"}},
+	}
+	estimate, err := estimateCost(&prices, specs, time.Hour, "test")
+	if err != nil {
+		t.Fatalf("estimateCost() with the embedded prices: %v", err)
+	}
+	if estimate.Currency != "USD" || len(estimate.Unpriced) > 0 {
+		t.Errorf("estimate = %+v, want every machine priced in USD", estimate)
+	}
+
+	hetzner := prices.Providers["hetzner"]
+	hetzner.Currency = "EUR"
+	prices.Providers["hetzner"] = hetzner
+	if _, err := estimateCost(&prices, specs, time.Hour, "test"); err == nil {
+		t.Errorf("estimateCost() added up USD and EUR prices")
+	}
+}
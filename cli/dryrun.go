@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// dryRunCIDR stands in for the public IP address of the CLI in the plans of a
+// dry run, which doesn't contact any service. It is a documentation address.
+const dryRunCIDR = "192.0.2.1/32"
+
+// dryRun prints what the benchmark would do without provisioning anything:
+// the commands, the staged files and the resources Terraform would create for
+// every machine of specs, none with --host. It returns false if a plan failed.
+func dryRun(opts runScriptOptions, stagedFolder, runID string, specs []machineSpec, key *sshKey) bool {
+	infoLog("Dry run: nothing will be provisioned or run")
+
+	for _, variant := range opts.variants() {
+		label := "Remote command"
+		if variant.Name != "" {
+			label += " (" + variant.Name + ")"
+		}
+		infoLog("%s: %s", label, variant.command())
+	}
+	// The script itself is printed with --debug
+	infoLog("Started with: bash %s/%s", remoteBenchmarkDir, runScriptName)
+
+	if err := printStagedFiles(stagedFolder); err != nil {
+		errorLog("Failed to list the staged files: %v", err)
+		return false
+	}
+
+	ok := true
+	for i, spec := range specs {
+		moduleDir, err := stageTerraformModule(spec.Cloud)
+		if err != nil {
+			errorLog("Failed to prepare the Terraform module: %v", err)
+			return false
+		}
+		name := "ib-" + runID
+		if len(specs) > 1 {
+			name = fmt.Sprintf("ib-%s-%d", runID, i+1)
+		}
+		resources, err := planMachine(moduleDir, terraformVars(spec.Vars, name), key)
+		// Only a plan was made, there is no state to keep
+		os.RemoveAll(moduleDir)
+		if err != nil {
+			errorLog("%s: %v", spec.Name, err)
+			ok = false
+			continue
+		}
+		infoLog("Resources to create for %s:", spec.Name)
+		for _, resource := range resources {
+			infoLog("  %s", resource)
+		}
+	}
+	return ok
+}
+
+// printStagedFiles lists the files of dir that would be uploaded, with their
+// size.
+func printStagedFiles(dir string) error {
+	rows := [][]string{{"File", "Size"}}
+	var total int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		relative, _ := filepath.Rel(dir, path)
+		rows = append(rows, []string{relative, formatSize(info.Size())})
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	infoLog("Files to upload to ~/%s: %d, %s", remoteBenchmarkDir, len(rows)-1, formatSize(total))
+	printTable(rows)
+	return nil
+}
+
+// formatSize prints a size in bytes with a binary unit.
+func formatSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < 4 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, strings.ToUpper(" kmgt")[unit])
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// envFileName and secretsFileName are staged next to the run script and
+// sourced by it. They never go through Terraform variables, so their content
+// doesn't end up in terraform.tfstate.
+const (
+	envFileName     = ".ib-env"
+	secretsFileName = ".ib-secrets"
+)
+
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// secretValues holds every secret passed with --secret so they can be redacted
+// from anything printed by the CLI.
+var secretValues []string
+
+// parseEnvAssignment parses a KEY=VALUE pair as accepted by --env and
+// --env-file.
+func parseEnvAssignment(assignment string) (string, string, error) {
+	key, value, ok := strings.Cut(assignment, "=")
+	key = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(key), "export "))
+	if !ok || !envKeyPattern.MatchString(key) {
+		return "", "", fmt.Errorf("invalid environment variable %q, expected KEY=VALUE", assignment)
+	}
+
+	// Strip matching quotes, as commonly found in .env files
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = value[1 : len(value)-1]
+	}
+	return key, value, nil
+}
+
+// readEnvFile reads KEY=VALUE lines from a .env style file, ignoring blank
+// lines and comments.
+func readEnvFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	vars := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, err := parseEnvAssignment(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, lineNumber, err)
+		}
+		vars[key] = value
+	}
+	return vars, scanner.Err()
+}
+
+// readSecrets looks up each --secret name in the local environment.
+func readSecrets(names []string) (map[string]string, error) {
+	secrets := make(map[string]string)
+	for _, name := range names {
+		if !envKeyPattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid secret name %q", name)
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("secret %s is not set in the local environment", name)
+		}
+		secrets[name] = value
+	}
+	return secrets, nil
+}
+
+// writeEnvFile writes vars as shell exports, readable only by the owner.
+func writeEnvFile(path string, vars map[string]string) error {
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, "export %s=%s\n", key, shellQuote(vars[key]))
+	}
+	return os.WriteFile(path, []byte(b.String()), 0600)
+}
+
+// shellQuote wraps value in single quotes so bash takes it literally.
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// redact replaces every secret value in text with a placeholder.
+func redact(text string) string {
+	for _, secret := range secretValues {
+		if secret != "" {
+			text = strings.ReplaceAll(text, secret, "***")
+		}
+	}
+	return text
+}
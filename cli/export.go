@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// exportTarget is a --export value, e.g. "csv:results.csv".
+type exportTarget struct {
+	Format string
+	Path   string
+}
+
+// parseExport reads a --export value.
+func parseExport(value string) (exportTarget, error) {
+	format, path, ok := strings.Cut(value, ":")
+	if !ok || path == "" {
+		return exportTarget{}, fmt.Errorf("%q should be FORMAT:PATH, e.g. csv:results.csv", value)
+	}
+	switch format {
+	case "csv", "junit":
+		return exportTarget{Format: format, Path: path}, nil
+	}
+	return exportTarget{}, fmt.Errorf("unsupported format %q, use csv or junit", format)
+}
+
+// writeExport writes result to target. Comparisons regressing by more than
+// threshold fail in JUnit, none do when it is zero.
+func writeExport(result *BenchmarkResult, target exportTarget, threshold float64) error {
+	if dir := filepath.Dir(target.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	file, err := os.Create(target.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if target.Format == "junit" {
+		if _, err := file.WriteString(xml.Header); err != nil {
+			return err
+		}
+		encoder := xml.NewEncoder(file)
+		encoder.Indent("", "  ")
+		if err := encoder.Encode(junitReport(result, threshold)); err != nil {
+			return err
+		}
+		_, err = file.WriteString("\n")
+		return err
+	}
+
+	writer := csv.NewWriter(file)
+	for _, record := range csvRecords(result) {
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// csvRecords returns a header and one record per run and metric, with raw
+// values: seconds, KB or counts.
+func csvRecords(result *BenchmarkResult) [][]string {
+	records := [][]string{{"run_id", "started_at", "machine", "command", "variant", "run", "exit_code", "metric", "value"}}
+	for _, run := range result.Runs {
+		command := result.Command
+		if run.Variant == baselineVariant && result.BaselineCommand != "" {
+			command = result.BaselineCommand
+		}
+		for _, metric := range runMetrics {
+			if !metric.available(run) {
+				continue
+			}
+			records = append(records, []string{
+				result.ID,
+				result.StartedAt.UTC().Format("2006-01-02T15:04:05Z"),
+				machineName(systemOf(result, run.Variant)),
+				command,
+				run.Variant,
+				strconv.Itoa(run.Index),
+				strconv.Itoa(run.ExitCode),
+				metric.name,
+				strconv.FormatFloat(metric.value(run), 'f', -1, 64),
+			})
+		}
+	}
+	return records
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// junitReport turns result into a test suite: a testcase per benchmarked
+// variant, failing when one of its runs failed, and one per comparison,
+// bisect step and regression gate, failing on regressions.
+func junitReport(result *BenchmarkResult, threshold float64) junitTestSuites {
+	suite := junitTestSuite{
+		Name:      "ib-agent-cli " + result.ID,
+		Timestamp: result.StartedAt.UTC().Format("2006-01-02T15:04:05"),
+	}
+	wall, _ := findMetric("wall")
+	total := 0.0
+
+	for _, variant := range variantsOf(result.Runs) {
+		runs := runsOf(result.Runs, variant)
+		command := result.Command
+		if variant == baselineVariant && result.BaselineCommand != "" {
+			command = result.BaselineCommand
+		}
+		duration := 0.0
+		var failed []string
+		for _, run := range runs {
+			duration += run.Wall
+			if run.ExitCode != 0 {
+				failed = append(failed, fmt.Sprintf("run %d exited with code %d", run.Index, run.ExitCode))
+			}
+		}
+		total += duration
+		testCase := junitTestCase{
+			Name:      strings.TrimSpace(variant + " " + command),
+			ClassName: "benchmark",
+			Time:      junitSeconds(duration),
+			SystemOut: fmt.Sprintf("%d runs on %s, mean wall time %s", len(runs), machineName(systemOf(result, variant)), wall.format(meanOf(runs, wall))),
+		}
+		if len(failed) > 0 {
+			testCase.Failure = &junitFailure{Message: "benchmark command failed", Type: "benchmark", Text: strings.Join(failed, "\n")}
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	if result.Failed && len(result.Runs) == 0 && result.Bisect == nil {
+		suite.Cases = append(suite.Cases, junitTestCase{
+			Name:      result.Command,
+			ClassName: "benchmark",
+			Time:      junitSeconds(0),
+			Failure:   &junitFailure{Message: "benchmark failed", Type: "benchmark"},
+		})
+	}
+
+	for _, comparison := range result.Comparisons {
+		testCase := junitTestCase{
+			Name:      fmt.Sprintf("%s vs %s: %s", comparison.Candidate, comparison.Baseline, comparison.Metric),
+			ClassName: "comparison",
+			Time:      junitSeconds(0),
+			SystemOut: fmt.Sprintf("%s (p=%s)", formatChange(comparison.Change), formatPValue(comparison.PValue)),
+		}
+		if threshold > 0 && regression(comparison.Metric, comparison.Change) > threshold {
+			testCase.Failure = &junitFailure{
+				Message: fmt.Sprintf("%s regressed by %s, above the %s threshold", comparison.Metric, formatChange(comparison.Change), formatChange(threshold)),
+				Type:    "regression",
+			}
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	if bisect := result.Bisect; bisect != nil {
+		for _, step := range bisect.Steps {
+			testCase := junitTestCase{
+				Name:      fmt.Sprintf("%s %s: %s", step.Commit, step.Subject, bisect.Metric),
+				ClassName: "bisect",
+				Time:      junitSeconds(0),
+				SystemOut: fmt.Sprintf("%s against %s (p=%s)", formatChange(step.Change), bisect.Good, formatPValue(step.PValue)),
+			}
+			if step.Regressed {
+				testCase.Failure = &junitFailure{
+					Message: fmt.Sprintf("%s regressed by %s, above the %s threshold", bisect.Metric, formatChange(step.Change), formatChange(bisect.Threshold)),
+					Type:    "regression",
+				}
+			}
+			suite.Cases = append(suite.Cases, testCase)
+		}
+	}
+
+	// A gate without --against is already failing its comparison testcase
+	if gate := result.Gate; gate != nil && !gatesComparison(result, gate) {
+		testCase := junitTestCase{
+			Name:      "wall time against " + gate.Against,
+			ClassName: "regression-gate",
+			Time:      junitSeconds(0),
+			SystemOut: fmt.Sprintf("%s (%s → %s, p=%s)", formatChange(gate.Wall.Change), formatSeconds(gate.Wall.BaselineMean), formatSeconds(gate.Wall.CandidateMean), formatPValue(gate.Wall.PValue)),
+		}
+		if gate.Regressed {
+			testCase.Failure = &junitFailure{
+				Message: fmt.Sprintf("wall time regressed by %s, above the %s threshold", formatChange(gate.Wall.Change), formatChange(gate.Threshold)),
+				Type:    "regression",
+			}
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+
+	suite.Tests = len(suite.Cases)
+	for _, testCase := range suite.Cases {
+		if testCase.Failure != nil {
+			suite.Failures++
+		}
+	}
+	suite.Time = junitSeconds(total)
+	return junitTestSuites{Suites: []junitTestSuite{suite}}
+}
+
+func gatesComparison(result *BenchmarkResult, gate *GateResult) bool {
+	for _, comparison := range result.Comparisons {
+		if comparison.Baseline == gate.Against {
+			return true
+		}
+	}
+	return false
+}
+
+func junitSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 3, 64)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// publicIPService answers with the public IPv4 address of the caller.
+const publicIPService = "https://checkip.amazonaws.com"
+
+// detectPublicIP asks publicIPService for the address provisioned machines
+// see connections coming from.
+func detectPublicIP() (string, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	response, err := client.Get(publicIPService)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s answered %s", publicIPService, response.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, 256))
+	if err != nil {
+		return "", err
+	}
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return "", fmt.Errorf("%s answered %q, not an IP address", publicIPService, strings.TrimSpace(string(body)))
+	}
+	return ip.String(), nil
+}
+
+// parseAllowedCIDRs reads --allow-cidr values, CIDR blocks or single
+// addresses separated by commas.
+func parseAllowedCIDRs(values []string) ([]string, error) {
+	var cidrs []string
+	for _, value := range values {
+		for _, cidr := range strings.Split(value, ",") {
+			cidr, err := parseCIDR(strings.TrimSpace(cidr))
+			if err != nil {
+				return nil, err
+			}
+			if !contains(cidrs, cidr) {
+				cidrs = append(cidrs, cidr)
+			}
+		}
+	}
+	return cidrs, nil
+}
+
+// parseCIDR normalizes a CIDR block, a single address becoming a /32 or /128.
+func parseCIDR(value string) (string, error) {
+	if ip := net.ParseIP(value); ip != nil {
+		if ip.To4() != nil {
+			return ip.String() + "/32", nil
+		}
+		return ip.String() + "/128", nil
+	}
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return "", fmt.Errorf("%q is neither an IP address nor a CIDR block", value)
+	}
+	return network.String(), nil
+}
+
+// parseOpenPorts reads --open-port values, TCP ports separated by commas.
+func parseOpenPorts(values []string) ([]int, error) {
+	var ports []int
+	for _, value := range values {
+		for _, field := range strings.Split(value, ",") {
+			port, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || port < 1 || port > 65535 {
+				return nil, fmt.Errorf("%q is not a TCP port", field)
+			}
+			if port == 22 {
+				continue
+			}
+			if !containsPort(ports, port) {
+				ports = append(ports, port)
+			}
+		}
+	}
+	return ports, nil
+}
+
+func containsPort(ports []int, port int) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// addFirewallVars sets the Terraform variables restricting the inbound
+// connections of a machine to cidrs, on SSH and ports.
+func addFirewallVars(vars map[string]string, cidrs []string, ports []int) {
+	// JSON lists are valid HCL
+	encodedCIDRs, _ := json.Marshal(cidrs)
+	vars["allowed_cidrs"] = string(encodedCIDRs)
+	if len(ports) > 0 {
+		encodedPorts, _ := json.Marshal(ports)
+		vars["open_ports"] = string(encodedPorts)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Exit codes of the run, compare and bisect commands, so CI can tell a
+// regression from a broken benchmark or leaked resources. When several
+// apply, the highest one is used. Invalid options and other errors exit
+// with 1.
+const (
+	exitRegression         = 2
+	exitBenchmarkFailed    = 3
+	exitProvisioningFailed = 4
+	exitCleanupFailed      = 5
+	exitSpotInterrupted    = 6
+)
+
+// GateResult is the outcome of --fail-if-slower-than.
+type GateResult struct {
+	Against   string           `json:"against"`
+	Threshold float64          `json:"threshold"`
+	Wall      MetricComparison `json:"wall"`
+	Regressed bool             `json:"regressed"`
+}
+
+// loadAgainst reads the result given to --against: a JSON file holding a
+// result, or the last one of a JSON-lines file like the history, or the ID of
+// a run in the history.
+func loadAgainst(value string) (*BenchmarkResult, error) {
+	if fileExists(value) {
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return nil, err
+		}
+		lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+		var result BenchmarkResult
+		if err := json.Unmarshal(lines[len(lines)-1], &result); err != nil {
+			if err := json.Unmarshal(data, &result); err != nil {
+				return nil, fmt.Errorf("%s is not a benchmark result: %v", value, err)
+			}
+		}
+		return checkAgainst(&result, value)
+	}
+
+	results, err := loadHistory()
+	if err != nil {
+		return nil, err
+	}
+	result, err := findHistory(results, value)
+	if err != nil {
+		return nil, err
+	}
+	return checkAgainst(result, value)
+}
+
+func checkAgainst(result *BenchmarkResult, value string) (*BenchmarkResult, error) {
+	if result.Failed {
+		return nil, fmt.Errorf("%s is a failed benchmark", value)
+	}
+	if _, ok := metricValues(measuredRuns(result.Runs), runMetrics[0]); !ok {
+		return nil, fmt.Errorf("%s has no measured runs", value)
+	}
+	return result, nil
+}
+
+// measuredRuns returns the runs of the last variant, the candidate when
+// comparing against a baseline.
+func measuredRuns(runs []RunResult) []RunResult {
+	variants := variantsOf(runs)
+	if len(variants) == 0 {
+		return nil
+	}
+	return runsOf(runs, variants[len(variants)-1])
+}
+
+// checkRegression compares the wall time of result with against and reports
+// whether it is slower by more than threshold.
+func checkRegression(result, against *BenchmarkResult, threshold float64) *GateResult {
+	gate := &GateResult{Against: against.ID, Threshold: threshold}
+	for _, comparison := range compareRuns(measuredRuns(against.Runs), measuredRuns(result.Runs), against.ID, result.ID) {
+		if comparison.Metric == "wall" {
+			gate.Wall = comparison
+		}
+	}
+	gate.Regressed = gate.Wall.Change > threshold
+
+	if machineName(against.System) != machineName(result.System) {
+		infoLog("%s ran on %s, not %s: the difference includes the machines", against.ID, machineName(against.System), machineName(result.System))
+	}
+	logGate(gate)
+	return gate
+}
+
+// checkComparisons applies --fail-if-slower-than to the comparisons of a run
+// without --against, gating on the candidate whose wall time changed most.
+func checkComparisons(result *BenchmarkResult, threshold float64) *GateResult {
+	var gate *GateResult
+	for _, comparison := range result.Comparisons {
+		if comparison.Metric != "wall" || gate != nil && comparison.Change <= gate.Wall.Change {
+			continue
+		}
+		gate = &GateResult{Against: comparison.Baseline, Threshold: threshold, Wall: comparison}
+	}
+	if gate == nil {
+		return nil
+	}
+	gate.Regressed = gate.Wall.Change > threshold
+	logGate(gate)
+	return gate
+}
+
+func logGate(gate *GateResult) {
+	if gate.Regressed {
+		errorLog("Regression: wall time %s (%s → %s) against %s, above the %s threshold (p=%s)",
+			formatChange(gate.Wall.Change), formatSeconds(gate.Wall.BaselineMean), formatSeconds(gate.Wall.CandidateMean),
+			gate.Against, formatChange(gate.Threshold), formatPValue(gate.Wall.PValue))
+	} else {
+		successLog("No regression: wall time %s (%s → %s) against %s, within the %s threshold",
+			formatChange(gate.Wall.Change), formatSeconds(gate.Wall.BaselineMean), formatSeconds(gate.Wall.CandidateMean),
+			gate.Against, formatChange(gate.Threshold))
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// historyFile is the JSON-lines file of ~/.ib/history every benchmark result
+// is appended to.
+const historyFile = "runs.jsonl"
+
+// historyDir returns ~/.ib/history.
+func historyDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".ib", "history"), nil
+}
+
+// saveHistory appends result to the history.
+func saveHistory(result *BenchmarkResult) error {
+	dir, err := historyDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	line, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, historyFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// loadHistory reads every result of the history, oldest first.
+func loadHistory() ([]BenchmarkResult, error) {
+	dir, err := historyDir()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(dir, historyFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var results []BenchmarkResult
+	scanner := bufio.NewScanner(file)
+	// Results embed the output of every run
+	scanner.Buffer(make([]byte, 1024*1024), 256*1024*1024)
+	for scanner.Scan() {
+		var result BenchmarkResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			debugLog("Skipping unreadable history entry: %v", err)
+			continue
+		}
+		results = append(results, result)
+	}
+	return results, scanner.Err()
+}
+
+// findHistory returns the result whose ID starts with id.
+func findHistory(results []BenchmarkResult, id string) (*BenchmarkResult, error) {
+	var found *BenchmarkResult
+	for i := range results {
+		if strings.HasPrefix(results[i].ID, id) {
+			if found != nil {
+				return nil, fmt.Errorf("%q matches several runs", id)
+			}
+			found = &results[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no run %q in the history", id)
+	}
+	return found, nil
+}
+
+// gitCommit returns the commit checked out in dir, suffixed with "-dirty"
+// when it has uncommitted changes, or "" outside of a git repository.
+func gitCommit(dir string) string {
+	head, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	commit := strings.TrimSpace(string(head))
+	status, err := exec.Command("git", "-C", dir, "status", "--porcelain").Output()
+	if err == nil && len(strings.TrimSpace(string(status))) > 0 {
+		commit += "-dirty"
+	}
+	return commit
+}
+
+// machineName describes where a result was measured, e.g. "aws c6i.large".
+func machineName(system SystemInfo) string {
+	if system.InstanceType != "" {
+		return system.Provider + " " + system.InstanceType
+	}
+	return system.Provider
+}
+
+// historyFilter selects results by the flags shared by history and trend.
+type historyFilter struct {
+	instanceType *string
+	provider     *string
+	command      *string
+}
+
+func newHistoryFilter(flags *flag.FlagSet) historyFilter {
+	return historyFilter{
+		instanceType: flags.String("instance-type", "", "Only show runs on this instance or server type"),
+		provider:     flags.String("provider", "", "Only show runs on this provider, e.g. aws, hetzner or host"),
+		command:      flags.String("command", "", "Only show runs whose command contains this text"),
+	}
+}
+
+func (f historyFilter) match(result BenchmarkResult) bool {
+	if *f.instanceType != "" && result.System.InstanceType != *f.instanceType {
+		return false
+	}
+	if *f.provider != "" && !strings.HasPrefix(result.System.Provider, *f.provider) {
+		return false
+	}
+	return *f.command == "" || strings.Contains(result.Command, *f.command)
+}
+
+// historyCommand lists the runs of the history, or shows one of them.
+func historyCommand(arguments []string) {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+	flags.Usage = printUsageAndExit
+	filter := newHistoryFilter(flags)
+	limit := flags.Int("limit", 20, "Number of runs to list, most recent last")
+	flags.Parse(arguments)
+
+	results, err := loadHistory()
+	if err != nil {
+		errorLog("Failed to read the history: %v", err)
+		os.Exit(1)
+	}
+
+	if flags.NArg() > 0 {
+		result, err := findHistory(results, flags.Arg(0))
+		if err != nil {
+			errorLog("%v", err)
+			os.Exit(1)
+		}
+		printHistoryEntry(result)
+		return
+	}
+
+	var matching []BenchmarkResult
+	for _, result := range results {
+		if filter.match(result) {
+			matching = append(matching, result)
+		}
+	}
+	if len(matching) == 0 {
+		infoLog("No runs in the history")
+		return
+	}
+	if *limit > 0 && len(matching) > *limit {
+		matching = matching[len(matching)-*limit:]
+	}
+
+	wall, _ := findMetric("wall")
+	rows := [][]string{{"ID", "Date", "Machine", "Commit", "Status", "Runs", "Wall", "Command"}}
+	for _, result := range matching {
+		// Compared variants have no single mean
+		mean := ""
+		if runs := runsOf(result.Runs, ""); len(runs) > 0 {
+			mean = wall.format(meanOf(runs, wall))
+		}
+		status := "ok"
+		if result.Interrupted {
+			status = "interrupted"
+		} else if result.Failed {
+			status = "failed"
+		}
+		rows = append(rows, []string{
+			result.ID,
+			result.StartedAt.Local().Format("2006-01-02 15:04"),
+			machineName(result.System),
+			shortCommit(result.GitCommit),
+			status,
+			strconv.Itoa(len(result.Runs)),
+			mean,
+			truncate(result.Command, 50),
+		})
+	}
+	printTable(rows)
+}
+
+// printHistoryEntry prints a stored result like at the end of its run.
+func printHistoryEntry(result *BenchmarkResult) {
+	infoLog("Run:      %s, %s", result.ID, result.StartedAt.Local().Format("2006-01-02 15:04:05"))
+	infoLog("Command:  %s", result.Command)
+	if result.ServerCommand != "" {
+		infoLog("Server:   %s", result.ServerCommand)
+	}
+	if result.GitCommit != "" {
+		infoLog("Commit:   %s", result.GitCommit)
+	}
+	if result.Cost != nil {
+		infoLog("Cost:     %s", costLine(result.Cost))
+	}
+	if len(result.Interruptions) > 0 {
+		infoLog("Spot:     interrupted, %s", interruptionsLine(result))
+	}
+	if len(result.Parameters) > 0 {
+		var params []string
+		for _, name := range sortedKeys(result.Parameters) {
+			params = append(params, "--"+name+"="+result.Parameters[name])
+		}
+		infoLog("Options:  %s", strings.Join(params, " "))
+	}
+	if len(result.Machines) > 0 {
+		for _, name := range machineNames(result) {
+			infoLog("── %s ──", name)
+			printSystemInfo(result.Machines[name])
+		}
+	} else {
+		printSystemInfo(result.System)
+	}
+	printStabilizeSteps(result.Stabilization)
+	printSummaryTable(result.Runs)
+	printComparisons(result.Comparisons)
+}
+
+// trendCommand shows a metric of the matching runs over time, one table per
+// machine.
+func trendCommand(arguments []string) {
+	flags := flag.NewFlagSet("trend", flag.ExitOnError)
+	flags.Usage = printUsageAndExit
+	filter := newHistoryFilter(flags)
+	metricName := flags.String("metric", "wall", "Metric to show: "+strings.Join(metricNames(), ", "))
+	limit := flags.Int("limit", 30, "Number of runs to show per machine, most recent last")
+	flags.Parse(arguments)
+
+	metric, ok := findMetric(*metricName)
+	if !ok {
+		errorLog("Unknown --metric %q, use one of %s", *metricName, strings.Join(metricNames(), ", "))
+		os.Exit(1)
+	}
+	results, err := loadHistory()
+	if err != nil {
+		errorLog("Failed to read the history: %v", err)
+		os.Exit(1)
+	}
+
+	var machines []string
+	byMachine := make(map[string][]BenchmarkResult)
+	for _, result := range results {
+		// Runs comparing variants are left out, like failed ones
+		result.Runs = runsOf(result.Runs, "")
+		if result.Failed || !filter.match(result) {
+			continue
+		}
+		if _, ok := metricValues(result.Runs, metric); !ok {
+			continue
+		}
+		name := machineName(result.System)
+		if _, ok := byMachine[name]; !ok {
+			machines = append(machines, name)
+		}
+		byMachine[name] = append(byMachine[name], result)
+	}
+	if len(machines) == 0 {
+		infoLog("No runs in the history measured %s", metric.name)
+		return
+	}
+
+	for _, name := range machines {
+		entries := byMachine[name]
+		if *limit > 0 && len(entries) > *limit {
+			entries = entries[len(entries)-*limit:]
+		}
+		highest := 0.0
+		for _, result := range entries {
+			highest = max(highest, meanOf(result.Runs, metric))
+		}
+
+		infoLog("%s on %s", metric.header, name)
+		rows := [][]string{{"Date", "ID", "Commit", metric.header, "Change", ""}}
+		previous := 0.0
+		for _, result := range entries {
+			value := meanOf(result.Runs, metric)
+			change := ""
+			if previous != 0 {
+				change = formatChange((value - previous) / previous)
+			}
+			previous = value
+			bar := ""
+			if highest > 0 {
+				bar = strings.Repeat("█", int(value/highest*30+0.5))
+			}
+			rows = append(rows, []string{
+				result.StartedAt.Local().Format("2006-01-02 15:04"),
+				result.ID,
+				shortCommit(result.GitCommit),
+				metric.format(value),
+				change,
+				bar,
+			})
+		}
+		printTable(rows)
+	}
+}
+
+func shortCommit(commit string) string {
+	if len(commit) > 7 {
+		dirty := strings.HasSuffix(commit, "-dirty")
+		commit = commit[:7]
+		if dirty {
+			commit += "-dirty"
+		}
+	}
+	return commit
+}
+
+func truncate(text string, length int) string {
+	if len(text) <= length {
+		return text
+	}
+	return text[:length-3] + "..."
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
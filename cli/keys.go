@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshKey is the key pair of the machines provisioned for a run. It is
+// generated locally, so that only the public key reaches Terraform and its
+// state. The private key lives in a file readable only by the current user,
+// until remove is called at cleanup.
+type sshKey struct {
+	RunID     string
+	PublicKey string
+	Path      string
+}
+
+// newSSHKey generates the ed25519 key pair of the run runID.
+func newSSHKey(runID string) (*sshKey, error) {
+	name := "ib-" + runID
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	block, err := ssh.MarshalPrivateKey(privateKey, name)
+	if err != nil {
+		return nil, err
+	}
+
+	keyFile, err := os.CreateTemp("", "ib-key-")
+	if err != nil {
+		return nil, err
+	}
+	defer keyFile.Close()
+	key := &sshKey{
+		RunID:     runID,
+		PublicKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublicKey))) + " " + name,
+		Path:      keyFile.Name(),
+	}
+	// The file is restricted before the key is written to it
+	if err := keyFile.Chmod(0600); err != nil {
+		key.remove()
+		return nil, err
+	}
+	if err := pem.Encode(keyFile, block); err != nil {
+		key.remove()
+		return nil, err
+	}
+	debugLog("Generated SSH key %s", key.Path)
+	return key, nil
+}
+
+// terraformVar returns the KEY=VALUE variable passing the public key to the
+// Terraform module.
+func (k *sshKey) terraformVar() string {
+	return "public_key=" + k.PublicKey
+}
+
+// remove deletes the private key.
+func (k *sshKey) remove() error {
+	if k == nil {
+		return nil
+	}
+	if err := os.Remove(k.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove the SSH key %s: %v", k.Path, err)
+	}
+	return nil
+}
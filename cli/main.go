@@ -2,29 +2,30 @@ package main
 
 import (
 	"bytes"
-	"context"
-	"errors"
+	"crypto/rand"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math/bits"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/briandowns/spinner"
 	"github.com/fatih/color"
-	"github.com/hashicorp/go-version"
-	"github.com/hashicorp/hc-install/product"
-	"github.com/hashicorp/hc-install/releases"
-	"github.com/hashicorp/terraform-exec/tfexec"
 )
 
 var debugMode bool
 var spinnerInstance *spinner.Spinner
 
+// spinnersDisabled is set while several machines are handled concurrently
+var spinnersDisabled bool
+
 // Logger provides methods for printing debug and info messages
 func debugLog(format string, args ...interface{}) {
 	if debugMode {
@@ -32,7 +33,7 @@ func debugLog(format string, args ...interface{}) {
 			spinnerInstance.Stop()
 			defer spinnerInstance.Start()
 		}
-		color.Cyan("[DEBUG] "+format, args...)
+		color.Cyan("%s", redact(fmt.Sprintf("[DEBUG] "+format, args...)))
 	}
 }
 
@@ -41,24 +42,27 @@ func infoLog(format string, args ...interface{}) {
 		spinnerInstance.Stop()
 		defer spinnerInstance.Start()
 	}
-	color.Blue(format, args...)
+	color.Blue("%s", redact(fmt.Sprintf(format, args...)))
 }
 
 func errorLog(format string, args ...interface{}) {
 	if spinnerInstance != nil && spinnerInstance.Active() {
 		spinnerInstance.Stop()
 	}
-	color.Red("❌ "+format, args...)
+	color.Red("%s", redact(fmt.Sprintf("❌ "+format, args...)))
 }
 
 func successLog(format string, args ...interface{}) {
 	if spinnerInstance != nil && spinnerInstance.Active() {
 		spinnerInstance.Stop()
 	}
-	color.Green("✓ "+format, args...)
+	color.Green("%s", redact(fmt.Sprintf("✓ "+format, args...)))
 }
 
 func startSpinner(message string) {
+	if spinnersDisabled {
+		return
+	}
 	if spinnerInstance != nil {
 		spinnerInstance.Stop()
 	}
@@ -78,6 +82,7 @@ func filterOutput(output []byte) []byte {
 	lines := bytes.Split(output, []byte("\n"))
 	var filteredOutput []byte
 	captureOutput := false
+	inSection := false
 	for _, line := range lines {
 
 		if bytes.Contains(line, []byte("BENCHMARK_START")) {
@@ -94,12 +99,28 @@ func filterOutput(output []byte) []byte {
 			continue
 		}
 
-		if captureOutput == true {
-			// Remove "Remote-Output:" prefix if present
-			if bytes.Contains(line, []byte("Remote-Output:")) {
-				line = bytes.Replace(line, []byte("Remote-Output:"), []byte(""), 1)
-			}
-			
+		// Measurements are parsed by parseRuns, not printed
+		if bytes.Contains(line, []byte("IB_RUN_END")) || bytes.Contains(line, []byte("IB_PERF_STAT")) {
+			continue
+		}
+		if bytes.Contains(line, []byte(spotNoticeMarker)) {
+			continue
+		}
+
+		// Hook output (before-each/after-each) is reported separately
+		if bytes.Contains(line, []byte("IB_SECTION_START")) {
+			inSection = true
+			continue
+		}
+		if bytes.Contains(line, []byte("IB_SECTION_END")) {
+			inSection = false
+			continue
+		}
+
+		if captureOutput == true && !inSection {
+			// Remove the Terraform provisioner prefix if present
+			line = stripRemoteExecPrefix(line)
+
 			filteredOutput = append(filteredOutput, line...)
 			filteredOutput = append(filteredOutput, '\n')
 		}
@@ -107,26 +128,6 @@ func filterOutput(output []byte) []byte {
 	return filteredOutput
 }
 
-var terraformPath string
-
-func getTerraformDir() string {
-    if terraformPath == "" {
-		terraformPath = "../aws"
-    }
-
-    // Ensure the path is absolute
-    absolutePath, err := filepath.Abs(terraformPath)
-    if err != nil {
-        log.Fatalf("Failed to resolve absolute path: %v", err)
-    }
-
-    if _, err := os.Stat(absolutePath); os.IsNotExist(err) {
-        log.Fatalf("Terraform directory does not exist: %s", absolutePath)
-    }
-
-    return absolutePath
-}
-
 // copyDir recursively copies a directory tree, attempting to preserve permissions.
 func copyDir(src, dst string) error {
 	// Get properties of source dir
@@ -175,50 +176,433 @@ func copyDir(src, dst string) error {
 }
 
 func main() {
+	args := os.Args[1:]
+	name := "run"
+	if len(args) > 0 {
+		switch args[0] {
+		case "run", "compare", "bisect":
+			name, args = args[0], args[1:]
+		case "history":
+			historyCommand(args[1:])
+			return
+		case "trend":
+			trendCommand(args[1:])
+			return
+		case "ssh":
+			sshCommand(args[1:])
+			return
+		}
+	}
+	runCommand(name, args)
+}
+
+// runCommand runs the benchmark on a provisioned machine or on --host. The
+// compare command measures git revisions of --folder instead of its working
+// copy.
+func runCommand(name string, arguments []string) {
 	// Define command line flags
-	useExistingMachine := flag.String("host", "", "IP address of an existing machine to run the benchmark on")
-	sshKeyPath := flag.String("ssh-key", "", "Path to SSH private key for connecting to existing machine")
-	sshUser := flag.String("ssh-user", "ubuntu", "SSH username for connecting to existing machine")
-	folderPath := flag.String("folder", "", "Path to folder containing all dependencies to be copied")
-	command := flag.String("command", "", "Custom command to run on the instance")
-	instanceType := flag.String("instance-type", "t2.micro", "AWS instance type to use")
-	cloud := flag.String("cloud", "aws", "Cloud provider to use: aws or hetzner")
-	serverType := flag.String("server-type", "cax11", "Hetzner server type to use (for --cloud=hetzner)")
-	location := flag.String("location", "fsn1", "Hetzner location to use (for --cloud=hetzner)")
-	debug := flag.Bool("debug", false, "Enable debug logging")
-	flag.Parse()
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = printUsageAndExit
+	useExistingMachine := flags.String("host", "", "IP address of an existing machine to run the benchmark on")
+	sshKeyPath := flags.String("ssh-key", "", "Path to SSH private key for connecting to existing machine")
+	sshUser := flags.String("ssh-user", "ubuntu", "SSH username for connecting to existing machine")
+	folderPath := flags.String("folder", "", "Path to folder containing all dependencies to be copied")
+	command := flags.String("command", "", "Custom command to run on the instance")
+	instanceType := flags.String("instance-type", "t2.micro", "AWS instance type to use")
+	cloud := flags.String("cloud", "aws", "Cloud provider to use: aws or hetzner, or both separated by a comma to compare them")
+	serverType := flags.String("server-type", "cax11", "Hetzner server type to use (for --cloud=hetzner)")
+	location := flags.String("location", "fsn1", "Hetzner location to use (for --cloud=hetzner)")
+	runtimeFlag := flags.String("runtime", "node@22", "Runtimes to install before the benchmark, e.g. node@24, bun@1.1, deno@2, python@3.12, go@1.23 or none")
+	runs := flags.Int("runs", 3, "Number of measured runs")
+	warmups := flags.Int("warmups", 0, "Number of unmeasured warmup runs before the measured ones")
+	var setupCmds, teardownCmds, beforeEachCmds, afterEachCmds stringList
+	flags.Var(&setupCmds, "setup", "Command to run once before the warmups (repeatable)")
+	setupScript := flags.String("setup-script", "", "Path to a local script to run once before the warmups")
+	flags.Var(&teardownCmds, "teardown", "Command to run once after the last run (repeatable)")
+	flags.Var(&beforeEachCmds, "before-each", "Command to run before every measured run (repeatable)")
+	flags.Var(&afterEachCmds, "after-each", "Command to run after every measured run (repeatable)")
+	var envVars, envFiles, secretNames stringList
+	flags.Var(&envVars, "env", "Environment variable KEY=VALUE for the remote run (repeatable)")
+	flags.Var(&envFiles, "env-file", "Path to a file with KEY=VALUE lines for the remote run (repeatable)")
+	flags.Var(&secretNames, "secret", "Name of a local environment variable to pass as a secret (repeatable)")
+	var collectPatterns stringList
+	flags.Var(&collectPatterns, "collect", "Glob of files to download from the remote benchmark folder after the runs (repeatable)")
+	stabilize := flags.Bool("stabilize", false, "Reduce noise: performance governor, no turbo, CPU pinning, dropped page caches, settled load")
+	disableASLR := flags.Bool("disable-aslr", false, "Disable ASLR during the benchmark (implies --stabilize)")
+	perfMode := flags.String("perf", "", "Linux perf integration: stat (hardware counters per run) or record (flamegraphs)")
+	baselineCommand := flags.String("baseline-command", "", "Command to compare against, run interleaved with the benchmark on the same machine")
+	baselineFolder := flags.String("baseline-folder", "", "Folder replacing --folder for the baseline runs, compared on the same machine")
+	failIfSlower := flags.String("fail-if-slower-than", "", "Exit with code 2 if the wall time regressed by more than this percentage against --against or the baseline")
+	against := flags.String("against", "", "History run ID or result file to compare with --fail-if-slower-than")
+	reportFormat := flags.String("report", "", "Write a report of the results: markdown or html")
+	reportFile := flags.String("report-file", "", "Path of the --report file (default: results/<run-id>/report.md or .html)")
+	var exportValues stringList
+	maxCostFlag := flags.String("max-cost", "", "Refuse to provision machines whose estimated cost exceeds this amount, e.g. 5 (default: $IB_MAX_COST)")
+	confirmed := flags.Bool("yes", false, "Proceed even when the estimated cost exceeds --max-cost")
+	var allowCIDRs, openPorts stringList
+	flags.Var(&allowCIDRs, "allow-cidr", "CIDR block or IP address allowed to connect to provisioned machines (repeatable, default: your public IP)")
+	flags.Var(&openPorts, "open-port", "Additional TCP port to open on provisioned machines, from --allow-cidr (repeatable)")
+	serverCommand := flags.String("server-command", "", "Command starting a server on its own machine, loaded by --command from a client machine")
+	serverReady := flags.String("server-ready", "", "Port or URL telling when the --server-command server is ready, e.g. 8080 or 'http://$IB_SERVER_IP:8080/health'")
+	readyTimeout := flags.Duration("ready-timeout", 2*time.Minute, "How long to wait for --server-ready")
+	clientType := flags.String("client-type", "", "Instance or server type of the --server-command client machine (default: the same as the server)")
+	spot := flags.Bool("spot", false, "Benchmark on AWS spot instances, discarding the runs of interrupted ones")
+	spotMaxPrice := flags.String("spot-max-price", "", "Maximum hourly price of the --spot instances in USD (default: the on-demand price)")
+	spotRetries := flags.Int("spot-retries", 0, "Number of times an interrupted --spot benchmark is retried on a fresh instance")
+	dryRunning := flags.Bool("dry-run", false, "Stage the files, plan the machines and print what would run, without provisioning anything")
+	ttl := flags.Duration("ttl", 0, "Maximum lifetime of provisioned machines, e.g. 30m: the benchmark is stopped when it is reached")
+	terraformBinFlag := flags.String("terraform-bin", "", "Terraform binary to run instead of the cached download of Terraform 1.7.5 (default: $IB_TERRAFORM)")
+	pausing := flags.Bool("pause-before-destroy", false, "Open a shell on the provisioned machine once the benchmark is done, destroying it when the shell exits")
+	var matrixValues stringList
+	flags.Var(&matrixValues, "matrix", "Benchmark every combination of machines in parallel, e.g. instance-type=t3.small,c6i.large (repeatable)")
+	flags.Var(&exportValues, "export", "Export the results as csv:PATH or junit:PATH (repeatable)")
+	debug := flags.Bool("debug", false, "Enable debug logging")
+	var revs, revSetupCmds stringList
+	if name == "compare" {
+		flags.Var(&revs, "rev", "Git revision of --folder to compare, the first one being the baseline (repeatable)")
+		flags.Var(&revSetupCmds, "rev-setup", "Command to run once in every exported revision before the warmups (repeatable)")
+	}
+	var good, bad, bisectMetric, bisectThreshold *string
+	if name == "bisect" {
+		good = flags.String("good", "", "Git revision of --folder without the regression")
+		bad = flags.String("bad", "HEAD", "Git revision of --folder with the regression")
+		bisectMetric = flags.String("metric", "wall", "Metric to bisect: "+strings.Join(metricNames(), ", "))
+		bisectThreshold = flags.String("threshold", "5%", "Relative change of the metric counted as a regression")
+		flags.Var(&revSetupCmds, "rev-setup", "Command to run once in every exported revision before the warmups (repeatable)")
+	}
+	flags.Parse(arguments)
 
 	debugMode = *debug
+	terraformBin = valueOr(*terraformBinFlag, os.Getenv("IB_TERRAFORM"))
 
-    desiredDir := "aws"
-    switch strings.ToLower(*cloud) {
-    case "hetzner":
-        desiredDir = "hetzner"
-    case "aws":
-        desiredDir = "aws"
-    default:
-        errorLog("Unsupported cloud provider: %s. Use 'aws' or 'hetzner'", *cloud)
-        os.Exit(1)
-    }
+	runtimes, err := parseRuntimes(*runtimeFlag)
+	if err != nil {
+		errorLog("Invalid --runtime: %v", err)
+		os.Exit(1)
+	}
+	if *runs < 1 || *warmups < 0 {
+		errorLog("--runs must be at least 1 and --warmups cannot be negative")
+		os.Exit(1)
+	}
+	if *setupScript != "" && !fileExists(*setupScript) {
+		errorLog("Setup script not found: %s", *setupScript)
+		os.Exit(1)
+	}
 
-    basePath := terraformPath
-    if basePath == "" {
-        basePath = ".." 
-    }
-    absBase, err := filepath.Abs(basePath)
-    if err == nil {
-        // If the current base points to .../aws or .../hetzner, use its parent as repo base
-        last := filepath.Base(absBase)
-        if last == "aws" || last == "hetzner" {
-            absBase = filepath.Dir(absBase)
-        }
-        terraformPath = filepath.Join(absBase, desiredDir)
-    } else {
-        terraformPath = filepath.Join("..", desiredDir)
-    }
-    debugLog("Selected cloud provider: %s. Terraform dir set to %s", desiredDir, terraformPath)
+	// Environment files are applied first so --env can override them
+	remoteEnv := make(map[string]string)
+	for _, path := range envFiles {
+		vars, err := readEnvFile(path)
+		if err != nil {
+			errorLog("Failed to read env file: %v", err)
+			os.Exit(1)
+		}
+		for key, value := range vars {
+			remoteEnv[key] = value
+		}
+	}
+	for _, assignment := range envVars {
+		key, value, err := parseEnvAssignment(assignment)
+		if err != nil {
+			errorLog("Invalid --env: %v", err)
+			os.Exit(1)
+		}
+		remoteEnv[key] = value
+	}
+	secrets, err := readSecrets(secretNames)
+	if err != nil {
+		errorLog("Invalid --secret: %v", err)
+		os.Exit(1)
+	}
+	for _, value := range secrets {
+		secretValues = append(secretValues, value)
+	}
+	if err := validatePerfMode(*perfMode); err != nil {
+		errorLog("Invalid --perf: %v", err)
+		os.Exit(1)
+	}
+	if *perfMode == "record" {
+		// Flamegraphs are downloaded alongside the other artifacts
+		collectPatterns = append(collectPatterns, perfOutputDir+"/*")
+	}
+	if err := validateCollectPatterns(collectPatterns); err != nil {
+		errorLog("Invalid --collect: %v", err)
+		os.Exit(1)
+	}
+	if *baselineFolder != "" {
+		if *folderPath == "" {
+			errorLog("--baseline-folder replaces --folder for the baseline runs, --folder is required")
+			os.Exit(1)
+		}
+		if !dirExists(*baselineFolder) {
+			errorLog("Baseline folder not found: %s", *baselineFolder)
+			os.Exit(1)
+		}
+	}
+	baselining := *baselineCommand != "" || *baselineFolder != ""
+	if name == "compare" {
+		if len(revs) < 2 {
+			errorLog("compare needs at least two --rev, the first one being the baseline")
+			os.Exit(1)
+		}
+		if *folderPath == "" {
+			errorLog("compare exports the git revisions of --folder, --folder is required")
+			os.Exit(1)
+		}
+		if baselining {
+			errorLog("--baseline-command and --baseline-folder cannot be used with compare")
+			os.Exit(1)
+		}
+	}
+	var threshold float64
+	if name == "bisect" {
+		if *good == "" || *bad == "" {
+			errorLog("bisect needs the --good and --bad revisions")
+			os.Exit(1)
+		}
+		if *folderPath == "" {
+			errorLog("bisect exports the git revisions of --folder, --folder is required")
+			os.Exit(1)
+		}
+		if baselining {
+			errorLog("--baseline-command and --baseline-folder cannot be used with bisect")
+			os.Exit(1)
+		}
+		if _, ok := findMetric(*bisectMetric); !ok {
+			errorLog("Unknown --metric %q, use one of %s", *bisectMetric, strings.Join(metricNames(), ", "))
+			os.Exit(1)
+		}
+		threshold, err = parseThreshold(*bisectThreshold)
+		if err != nil {
+			errorLog("Invalid --threshold: %v", err)
+			os.Exit(1)
+		}
+	}
+	if err := validateReportFormat(*reportFormat); err != nil {
+		errorLog("Invalid --report: %v", err)
+		os.Exit(1)
+	}
+	if *reportFile != "" && *reportFormat == "" {
+		errorLog("--report-file needs --report=markdown or --report=html")
+		os.Exit(1)
+	}
+	var againstResult *BenchmarkResult
+	var gateThreshold float64
+	if *failIfSlower != "" || *against != "" {
+		if *failIfSlower == "" {
+			errorLog("--against needs --fail-if-slower-than")
+			os.Exit(1)
+		}
+		if *against == "" && !baselining && name != "compare" {
+			errorLog("--fail-if-slower-than needs --against, or a baseline or revisions to compare")
+			os.Exit(1)
+		}
+		if name == "bisect" {
+			errorLog("--fail-if-slower-than cannot be used with bisect")
+			os.Exit(1)
+		}
+		gateThreshold, err = parseThreshold(*failIfSlower)
+		if err != nil {
+			errorLog("Invalid --fail-if-slower-than: %v", err)
+			os.Exit(1)
+		}
+		if *against != "" {
+			againstResult, err = loadAgainst(*against)
+			if err != nil {
+				errorLog("Invalid --against: %v", err)
+				os.Exit(1)
+			}
+		}
+	}
+	var exports []exportTarget
+	for _, value := range exportValues {
+		target, err := parseExport(value)
+		if err != nil {
+			errorLog("Invalid --export: %v", err)
+			os.Exit(1)
+		}
+		exports = append(exports, target)
+	}
+	var maxCost *float64
+	if value := valueOr(*maxCostFlag, os.Getenv("IB_MAX_COST")); value != "" {
+		amount, err := parseCost(value)
+		if err != nil {
+			errorLog("Invalid --max-cost: %v", err)
+			os.Exit(1)
+		}
+		maxCost = &amount
+	}
+	if *ttl < 0 {
+		errorLog("--ttl cannot be negative")
+		os.Exit(1)
+	}
+	clouds, err := parseClouds(*cloud)
+	if err != nil {
+		errorLog("Invalid --cloud: %v", err)
+		os.Exit(1)
+	}
+	cidrs, err := parseAllowedCIDRs(allowCIDRs)
+	if err != nil {
+		errorLog("Invalid --allow-cidr: %v", err)
+		os.Exit(1)
+	}
+	ports, err := parseOpenPorts(openPorts)
+	if err != nil {
+		errorLog("Invalid --open-port: %v", err)
+		os.Exit(1)
+	}
+	if *useExistingMachine != "" && (len(cidrs) > 0 || len(ports) > 0) {
+		errorLog("--allow-cidr and --open-port configure provisioned machines, they cannot be used with --host")
+		os.Exit(1)
+	}
+	if *pausing && *useExistingMachine != "" {
+		errorLog("--pause-before-destroy keeps a provisioned machine, it cannot be used with --host")
+		os.Exit(1)
+	}
+	if *pausing && *ttl > 0 {
+		errorLog("--pause-before-destroy keeps the machine while its shell is open, it cannot be used with --ttl")
+		os.Exit(1)
+	}
+	if *useExistingMachine == "" && len(cidrs) == 0 && *dryRunning {
+		cidrs, _ = parseAllowedCIDRs([]string{dryRunCIDR})
+		infoLog("Dry run: planning with %s in place of your public IP address", cidrs[0])
+	} else if *useExistingMachine == "" && len(cidrs) == 0 {
+		startSpinner("Detecting your public IP address...")
+		ip, err := detectPublicIP()
+		stopSpinner()
+		if err != nil {
+			errorLog("Failed to detect your public IP address, give the addresses allowed to connect with --allow-cidr: %v", err)
+			os.Exit(1)
+		}
+		cidrs, _ = parseAllowedCIDRs([]string{ip})
+		infoLog("Only %s can connect to the machines, use --allow-cidr to change it", cidrs[0])
+	}
+	var probe *readinessProbe
+	serving := *serverCommand != ""
+	if serving {
+		switch {
+		case *useExistingMachine != "":
+			errorLog("--server-command provisions a server and a client machine, it cannot be used with --host")
+			os.Exit(1)
+		case baselining || name != "run" || len(matrixValues) > 0 || len(clouds) > 1:
+			errorLog("--server-command cannot be used with a baseline, compare, bisect, --matrix or several --cloud providers")
+			os.Exit(1)
+		case *spot || *perfMode != "":
+			errorLog("--server-command cannot be used with --spot or --perf")
+			os.Exit(1)
+		case *serverReady == "":
+			errorLog("--server-command needs --server-ready to know when the server accepts connections")
+			os.Exit(1)
+		case *readyTimeout <= 0:
+			errorLog("--ready-timeout must be positive")
+			os.Exit(1)
+		}
+		probe, err = parseReadinessProbe(*serverReady)
+		if err != nil {
+			errorLog("Invalid --server-ready: %v", err)
+			os.Exit(1)
+		}
+	} else if *serverReady != "" || *clientType != "" {
+		errorLog("--server-ready and --client-type need --server-command")
+		os.Exit(1)
+	}
+	var maxSpotPrice string
+	if *spot {
+		switch {
+		case *useExistingMachine != "":
+			errorLog("--spot provisions spot instances, it cannot be used with --host")
+			os.Exit(1)
+		case !contains(clouds, "aws"):
+			errorLog("--spot is only available with --cloud=aws")
+			os.Exit(1)
+		case *spotRetries < 0:
+			errorLog("--spot-retries cannot be negative")
+			os.Exit(1)
+		case *spotRetries > 0 && name == "bisect":
+			errorLog("--spot-retries cannot be used with bisect")
+			os.Exit(1)
+		}
+		if *spotMaxPrice != "" {
+			amount, err := parseCost(*spotMaxPrice)
+			if err != nil {
+				errorLog("Invalid --spot-max-price: %v", err)
+				os.Exit(1)
+			}
+			maxSpotPrice = strconv.FormatFloat(amount, 'f', -1, 64)
+		}
+		if len(clouds) > 1 {
+			infoLog("--spot only applies to the aws machines")
+		}
+	} else if *spotMaxPrice != "" || *spotRetries != 0 {
+		errorLog("--spot-max-price and --spot-retries need --spot")
+		os.Exit(1)
+	}
+	var axes []matrixAxis
+	for _, value := range matrixValues {
+		axis, err := parseMatrixAxis(value)
+		if err != nil {
+			errorLog("Invalid --matrix: %v", err)
+			os.Exit(1)
+		}
+		axes = append(axes, axis)
+	}
+	// Several providers are benchmarked side by side like a matrix
+	matrixing := len(axes) > 0 || len(clouds) > 1
+	if matrixing {
+		switch {
+		case *useExistingMachine != "":
+			errorLog("--matrix and several --cloud providers provision their machines, they cannot be used with --host")
+			os.Exit(1)
+		case baselining || name != "run":
+			errorLog("--matrix and several --cloud providers compare machines, they cannot be used with a baseline, compare or bisect")
+			os.Exit(1)
+		case *failIfSlower != "":
+			errorLog("--fail-if-slower-than cannot be used with --matrix or several --cloud providers")
+			os.Exit(1)
+		case *pausing:
+			errorLog("--pause-before-destroy opens a shell on a single machine, it cannot be used with --matrix or several --cloud providers")
+			os.Exit(1)
+		}
+	}
+	comparing := baselining || name == "compare" || name == "bisect" || matrixing
+	if comparing && *runs < 4 {
+		infoLog("With fewer than 4 runs per variant no difference can be significant, consider --runs=10")
+	}
+	runID := newRunID()
+	debugLog("Run ID: %s", runID)
+
+	desiredDir := clouds[0]
+	debugLog("Selected cloud provider: %s", desiredDir)
+
+	baseVars := machineVars(desiredDir, *instanceType, *serverType, *location)
+	if *spot && desiredDir == "aws" {
+		addSpotVars(baseVars, maxSpotPrice)
+	}
+	addFirewallVars(baseVars, cidrs, ports)
+	if serving {
+		baseVars["client"] = "true"
+		if *clientType != "" {
+			baseVars["client_type"] = *clientType
+		}
+	}
+	var matrix []machineSpec
+	if matrixing {
+		base := make(map[string]map[string]string)
+		for _, cloud := range clouds {
+			base[cloud] = machineVars(cloud, *instanceType, *serverType, *location)
+			if *spot && cloud == "aws" {
+				addSpotVars(base[cloud], maxSpotPrice)
+			}
+			addFirewallVars(base[cloud], cidrs, ports)
+		}
+		matrix, err = matrixSpecs(clouds, base, axes)
+		if err != nil {
+			errorLog("Invalid --matrix: %v", err)
+			os.Exit(1)
+		}
+	}
 
-	args := flag.Args()
+	args := flags.Args()
 	var binaryPath string
 	var cmdToRun string
 	var filesToCopy []string
@@ -234,9 +618,9 @@ func main() {
 			inferredBinary, err := exec.LookPath(cmdParts[0])
 			if err == nil {
 				binaryPath = inferredBinary
-				fmt.Printf("Inferred binary from command: %s\n", binaryPath)
+				fmt.Print(redact(fmt.Sprintf("Inferred binary from command: %s\n", binaryPath)))
 			} else {
-				fmt.Printf("Warning: Could not find binary '%s' in PATH. Will rely on remote system having it installed.\n", cmdParts[0])
+				fmt.Print(redact(fmt.Sprintf("Warning: Could not find binary '%s' in PATH. Will rely on remote system having it installed.\n", cmdParts[0])))
 			}
 		}
 	} else if len(args) > 1 {
@@ -260,9 +644,9 @@ func main() {
 			inferredBinary, err := exec.LookPath(cmdParts[0])
 			if err == nil {
 				binaryPath = inferredBinary
-				fmt.Printf("Inferred binary from command: %s\n", binaryPath)
+				fmt.Print(redact(fmt.Sprintf("Inferred binary from command: %s\n", binaryPath)))
 			} else {
-				fmt.Printf("Warning: Could not find binary '%s' in PATH. Will rely on remote system having it installed.\n", cmdParts[0])
+				fmt.Print(redact(fmt.Sprintf("Warning: Could not find binary '%s' in PATH. Will rely on remote system having it installed.\n", cmdParts[0])))
 			}
 		}
 	} else {
@@ -314,7 +698,7 @@ func main() {
 			if err == nil {
 				if !contains(filesToCopy, absPath) {
 					filesToCopy = append(filesToCopy, absPath)
-					fmt.Printf("Found file in command: %s\n", absPath)
+					fmt.Print(redact(fmt.Sprintf("Found file in command: %s\n", absPath)))
 				}
 			}
 		}
@@ -380,7 +764,17 @@ func main() {
 		}
 	}
 	
+	// The baseline runs the benchmark command unless told otherwise
+	baselineCmd := cmdToRun
+	if *baselineCommand != "" {
+		baselineCmd = *baselineCommand
+	}
+	serverCmd := *serverCommand
+
 	// Copy folder if specified
+	var revisions []revision
+	var commits []commit
+	var absFolder string
 	if *folderPath != "" {
 		// Convert to absolute path if needed
 		absPath := *folderPath
@@ -401,299 +795,622 @@ func main() {
 			log.Fatalf("%s is not a directory", absPath)
 		}
 		
-		fmt.Printf("Copying folder %s to benchmark environment...\n", absPath)
-		
 		// Preserve the folder structure by creating a subfolder with the same name
 		folderName := filepath.Base(absPath)
-		folderDestPath := filepath.Join(tmpFolder, folderName)
-		err = os.MkdirAll(folderDestPath, 0755)
+		absFolder = absPath
+		if name == "bisect" {
+			// Revisions are exported by every bisect step
+			commits, err = bisectCommits(absPath, *good, *bad)
+			if err != nil {
+				errorLog("Failed to list the commits to bisect: %v", err)
+				os.RemoveAll(fullTempFolder)
+				os.Exit(1)
+			}
+		} else if name == "compare" {
+			// Every revision gets the layout of a regular staged folder
+			startSpinner("Exporting " + strings.Join(revs, ", ") + " of " + folderName + "...")
+			revisions, err = exportRevisions(absPath, revs, tmpFolder)
+			stopSpinner()
+			if err != nil {
+				errorLog("Failed to export revisions: %v", err)
+				os.RemoveAll(fullTempFolder)
+				os.Exit(1)
+			}
+			for _, rev := range revisions {
+				successLog("Revision %s (%s) exported successfully", rev.Rev, rev.Commit)
+			}
+		} else {
+			fmt.Print(redact(fmt.Sprintf("Copying folder %s to benchmark environment...\n", absPath)))
+
+			folderDestPath := filepath.Join(tmpFolder, folderName)
+			err = os.MkdirAll(folderDestPath, 0755)
+			if err != nil {
+				errorLog("Failed to create directory %s: %v", folderDestPath, err)
+				os.Exit(1)
+			}
+
+			startSpinner("Copying " + folderName + " files...")
+			err = copyDir(absPath, folderDestPath)
+			stopSpinner()
+			if err != nil {
+				errorLog("Failed to copy folder: %v", err)
+				os.Exit(1)
+			}
+			successLog("Folder %s copied successfully", folderName)
+		}
+
+		// Adjust the command to use the correct paths in the remote environment
+		cmdToRun = remoteCommand(cmdToRun, *folderPath, folderName, remappedPaths)
+		debugLog("Adjusted command for remote environment: %s", cmdToRun)
+		if serving {
+			serverCmd = remoteCommand(serverCmd, *folderPath, folderName, remappedPaths)
+			debugLog("Adjusted server command for remote environment: %s", serverCmd)
+		}
+
+		// The baseline folder is staged under the same name in its own
+		// directory, so the paths of the command only gain a prefix
+		if *baselineFolder != "" {
+			baselineDest := filepath.Join(tmpFolder, baselineDir, folderName)
+			if err := os.MkdirAll(baselineDest, 0755); err != nil {
+				errorLog("Failed to create directory %s: %v", baselineDest, err)
+				os.Exit(1)
+			}
+			startSpinner("Copying baseline " + filepath.Base(*baselineFolder) + " files...")
+			err = copyDir(*baselineFolder, baselineDest)
+			stopSpinner()
+			if err != nil {
+				errorLog("Failed to copy baseline folder: %v", err)
+				os.Exit(1)
+			}
+			successLog("Baseline folder %s copied successfully", *baselineFolder)
+			baselineCmd = remoteCommand(baselineCmd, *folderPath, baselineDir+"/"+folderName, remappedPaths)
+		} else {
+			baselineCmd = remoteCommand(baselineCmd, *folderPath, folderName, remappedPaths)
+		}
+		debugLog("Adjusted baseline command for remote environment: %s", baselineCmd)
+	}
+
+	// The setup script travels with the staged files and runs after --setup
+	if *setupScript != "" {
+		err = copyFile(*setupScript, filepath.Join(tmpFolder, setupScriptName))
 		if err != nil {
-			errorLog("Failed to create directory %s: %v", folderDestPath, err)
+			errorLog("Failed to copy setup script: %v", err)
 			os.Exit(1)
 		}
-		
-		startSpinner("Copying " + folderName + " files...")
-		err = copyDir(absPath, folderDestPath)
-		stopSpinner()
+		setupCmds = append(setupCmds, "bash "+setupScriptName)
+	}
+
+	// Variables are staged as files sourced by the run script, keeping them
+	// out of the command line and of the Terraform variables and state
+	var envFile, secretsFile string
+	if len(remoteEnv) > 0 {
+		envFile = envFileName
+		err = writeEnvFile(filepath.Join(tmpFolder, envFile), remoteEnv)
 		if err != nil {
-			errorLog("Failed to copy folder: %v", err)
+			log.Fatalf("Failed to write environment file: %v", err)
+		}
+	}
+	if len(secrets) > 0 {
+		secretsFile = secretsFileName
+		err = writeEnvFile(filepath.Join(tmpFolder, secretsFile), secrets)
+		if err != nil {
+			log.Fatalf("Failed to write secrets file: %v", err)
+		}
+	}
+
+	var stabilizeOpts *stabilizeOptions
+	if *stabilize || *disableASLR {
+		// Provisioned machines are destroyed, only --host ones need restoring
+		stabilizeOpts = &stabilizeOptions{DisableASLR: *disableASLR, Restore: *useExistingMachine != ""}
+	}
+
+	// Baseline and candidate runs, or those of every revision, alternate on
+	// the same machine
+	var variants []scriptVariant
+	if baselining {
+		variants = []scriptVariant{
+			{Name: baselineVariant, Command: baselineCmd},
+			{Name: candidateVariant, Command: cmdToRun},
+		}
+	}
+	for _, rev := range revisions {
+		variants = append(variants, scriptVariant{Name: rev.Name, Command: cmdToRun, Dir: rev.Dir})
+	}
+
+	// Generate the script that prepares the runtime and runs the benchmark
+	scriptOpts := runScriptOptions{
+		Command:      cmdToRun,
+		Variants:     variants,
+		VariantSetup: revSetupCmds,
+		Runs:         *runs,
+		Warmups:      *warmups,
+		Runtimes:     runtimes,
+		EnvFile:      envFile,
+		SecretsFile:  secretsFile,
+		Setup:        setupCmds,
+		Teardown:     teardownCmds,
+		BeforeEach:   beforeEachCmds,
+		AfterEach:    afterEachCmds,
+		Stabilize:    stabilizeOpts,
+		Perf:         *perfMode,
+		Verbose:      debugMode,
+		SpotWatch:    *spot,
+		Ready:        probe,
+		ReadyTimeout: *readyTimeout,
+	}
+	scriptContent := buildRunScript(scriptOpts)
+	err = os.WriteFile(filepath.Join(tmpFolder, runScriptName), []byte(scriptContent), 0755)
+	if err != nil {
+		log.Fatalf("Failed to create benchmark script: %v", err)
+	}
+	debugLog("Generated %s:\n%s", runScriptName, scriptContent)
+	if serving {
+		serverScript := buildServerScript(scriptOpts, serverCmd)
+		if err := os.WriteFile(filepath.Join(tmpFolder, serverScriptName), []byte(serverScript), 0755); err != nil {
+			log.Fatalf("Failed to create server script: %v", err)
+		}
+		debugLog("Generated %s:\n%s", serverScriptName, serverScript)
+	}
+	
+	result := &BenchmarkResult{
+		ID:        runID,
+		StartedAt: time.Now(),
+		Command:   cmdToRun,
+	}
+	if baselining {
+		result.BaselineCommand = baselineCmd
+	}
+	if serving {
+		result.ServerCommand = serverCmd
+	}
+	result.GitCommit = gitCommit(".")
+	if *folderPath != "" {
+		result.GitCommit = gitCommit(*folderPath)
+	}
+	result.Parameters = make(map[string]string)
+	flags.Visit(func(f *flag.Flag) {
+		result.Parameters[f.Name] = redact(f.Value.String())
+	})
+	for _, rev := range revisions {
+		if result.Revisions == nil {
+			result.Revisions = make(map[string]string)
+		}
+		result.Revisions[rev.Name] = rev.Commit
+	}
+
+	// Provisioned machines are priced before anything is created
+	single := machineSpec{Name: desiredDir, Cloud: desiredDir, Vars: baseVars}
+	singles := []machineSpec{single}
+	if serving {
+		single.Name = "server"
+		client := machineSpec{Name: "client", Cloud: desiredDir,
+			Vars: machineVars(desiredDir, valueOr(*clientType, *instanceType), valueOr(*clientType, *serverType), *location)}
+		singles = []machineSpec{single, client}
+	}
+	var prices *priceTable
+	if *useExistingMachine == "" {
+		prices, err = loadPrices()
+		if err != nil {
+			errorLog("Failed to read the prices: %v", err)
+			os.RemoveAll(fullTempFolder)
 			os.Exit(1)
 		}
-		successLog("Folder %s copied successfully", folderName)
-		
-		// Adjust the command to use the correct paths in the remote environment
-		cmdParts = strings.Fields(cmdToRun)
-		if len(cmdParts) > 0 {
-			// Keep the binary name the same
-			newCmd := []string{cmdParts[0]}
-			
-			// Adjust paths for other arguments
-			for i := 1; i < len(cmdParts); i++ {
-				part := cmdParts[i]
-				// Remove any quotes
-				part = strings.Trim(part, "'\"")
-				
-				if strings.HasPrefix(part, *folderPath) {
-					// If the path starts with the folder path, replace it with the relative path
-					relPath, err := filepath.Rel(*folderPath, part)
-					if err == nil {
-						// Include the folder name in the path to maintain the structure
-						newPath := filepath.Join(folderName, relPath)
-						newCmd = append(newCmd, newPath)
-						continue
-					}
-				}
-				
-				// Check if this is a file path we've copied
-				absFilePath, err := filepath.Abs(part)
-				if err == nil && remappedPaths[absFilePath] != "" {
-					// Replace with just the file name
-					newCmd = append(newCmd, remappedPaths[absFilePath])
-				} else {
-					// Keep as is
-					newCmd = append(newCmd, part)
-				}
+		specs := matrix
+		if len(specs) == 0 {
+			specs = singles
+		}
+		duration, basis := *ttl, "--ttl"
+		if duration == 0 {
+			// Every variant runs, and every bisect step measures two of them
+			runCount := (*runs + *warmups) * max(1, len(variants))
+			if name == "bisect" {
+				runCount *= 2 * (bits.Len(uint(len(commits))) + 1)
 			}
-			
-			cmdToRun = strings.Join(newCmd, " ")
-			debugLog("Adjusted command for remote environment: %s", cmdToRun)
+			duration, basis = expectedRuntime(cmdToRun, runCount)
+		}
+		result.Cost, err = estimateCost(prices, specs, duration, basis)
+		if err != nil {
+			errorLog("Failed to estimate the cost: %v", err)
+			os.RemoveAll(fullTempFolder)
+			os.Exit(1)
+		}
+		if err := checkBudget(result.Cost, maxCost, *confirmed); err != nil && *dryRunning {
+			infoLog("Would not provision: %v", err)
+		} else if err != nil {
+			errorLog("Not provisioning: %v", err)
+			os.RemoveAll(fullTempFolder)
+			os.Exit(1)
 		}
 	}
-	
-	// Run on existing machine if specified
+
+	// Provisioned machines share a key pair generated for the run
+	var key *sshKey
+	if *useExistingMachine == "" {
+		key, err = newSSHKey(runID)
+		if err != nil {
+			errorLog("Failed to generate the SSH key: %v", err)
+			os.RemoveAll(fullTempFolder)
+			os.Exit(1)
+		}
+	}
+
+	if *dryRunning {
+		specs := matrix
+		if len(specs) == 0 && *useExistingMachine == "" {
+			specs = []machineSpec{single}
+		}
+		ok := dryRun(scriptOpts, tmpFolder, runID, specs, key)
+		os.RemoveAll(fullTempFolder)
+		key.remove()
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	var remote *remoteHost
+	var provisioned *machine
+	var provisionedAt time.Time
+	tfVars := terraformVars(baseVars, "ib-"+runID)
+	// Interrupted spot instances are paid for too
+	var interruptedCost float64
 	if *useExistingMachine != "" {
+		// Run on existing machine if specified
 		infoLog("Running benchmark on existing machine: %s", *useExistingMachine)
-		
+
 		// Validate SSH key if provided
 		if *sshKeyPath != "" {
 			if _, err := os.Stat(*sshKeyPath); os.IsNotExist(err) {
 				log.Fatalf("SSH key file not found: %s", *sshKeyPath)
 			}
 		}
-		
-		// Create benchmark directory on remote machine
-		var sshKeyOption string
-		if *sshKeyPath != "" {
-			sshKeyOption = "-i " + *sshKeyPath
+		remote = &remoteHost{Host: *useExistingMachine, User: *sshUser, KeyPath: *sshKeyPath}
+		result.System.Provider = "host " + *useExistingMachine
+	} else if len(matrix) == 0 {
+		// Otherwise, use Terraform to provision a new machine
+		provisionedAt = time.Now()
+		moduleDir, err := stageTerraformModule(desiredDir)
+		if err == nil {
+			provisioned, err = provisionMachine(moduleDir, tfVars, key)
 		}
-		
-		createDirCmd := exec.Command("ssh", strings.Split(sshKeyOption+" "+*sshUser+"@"+*useExistingMachine+" mkdir -p /home/"+*sshUser+"/benchmark", " ")...)
-		startSpinner("Preparing remote environment...")
-		createDirCmd.Stdout = os.Stdout
-		createDirCmd.Stderr = os.Stderr
-		err = createDirCmd.Run()
-		stopSpinner()
 		if err != nil {
-			errorLog("Failed to create benchmark directory on remote machine: %v", err)
-			os.Exit(1)
+			errorLog("%s", err)
+			os.RemoveAll(fullTempFolder)
+			key.remove()
+			if provisioned != nil && destroyMachine(provisioned, desiredDir) != nil {
+				os.Exit(exitCleanupFailed)
+			}
+			os.Exit(exitProvisioningFailed)
 		}
-		
-		// Copy files to remote machine
-		scpCmd := exec.Command("scp", append(strings.Split(sshKeyOption+" -r ", " "), 
-			tmpFolder+"/*", *sshUser+"@"+*useExistingMachine+":/home/"+*sshUser+"/benchmark/")...)
-		startSpinner("Copying files to remote machine...")
-		scpCmd.Stdout = os.Stdout
-		scpCmd.Stderr = os.Stderr
-		err = scpCmd.Run()
+		remote = provisioned.remote
+		if *ttl > 0 {
+			remote.Deadline = provisionedAt.Add(*ttl)
+		}
+		result.System.Provider = desiredDir
+		result.System.InstanceType = provisioned.instanceType
+		result.System.Location = provisioned.location
+		if serving && provisioned.client == nil {
+			errorLog("The Terraform module provisioned no client machine for --server-command")
+			os.RemoveAll(fullTempFolder)
+			key.remove()
+			if destroyMachine(provisioned, desiredDir) != nil {
+				os.Exit(exitCleanupFailed)
+			}
+			os.Exit(exitProvisioningFailed)
+		}
+	}
+
+	var benchmarkOK bool
+	provisioningOK, cleanupOK := true, true
+	if len(matrix) > 0 {
+		// Machines are provisioned, measured and destroyed together
+		benchmarkOK, provisioningOK, cleanupOK = runMatrix(matrix, matrixOptions{
+			StagedFolder: tmpFolder,
+			RunID:        runID,
+			Collect:      collectPatterns,
+			Prices:       prices,
+			TTL:          *ttl,
+			SpotRetries:  *spotRetries,
+			Key:          key,
+		}, result)
+	} else if name == "bisect" {
+		result.Bisect = &BisectResult{Good: *good, Bad: *bad, Metric: *bisectMetric, Threshold: threshold}
+		session := &bisectSession{
+			remote:       remote,
+			stagedFolder: tmpFolder,
+			folder:       absFolder,
+			opts:         scriptOpts,
+			good:         *good,
+			metric:       *bisectMetric,
+			threshold:    threshold,
+			result:       result,
+		}
+		benchmarkOK = session.run(commits)
+	} else if serving {
+		if *ttl > 0 {
+			provisioned.client.Deadline = remote.Deadline
+		}
+		benchmarkOK = runTopology(provisioned, topologyOptions{
+			StagedFolder: tmpFolder,
+			RunID:        runID,
+			Collect:      collectPatterns,
+			ClientType:   valueOr(*clientType, provisioned.instanceType),
+		}, result)
+		// The artifacts of the load generator are collected below
+		remote = provisioned.client
+	} else {
+		benchmarkOK = runBenchmark(remote, tmpFolder, result)
+		// The interrupted instance is replaced by a fresh one, with the same name
+		for retry := 1; result.Interrupted && retry <= *spotRetries; retry++ {
+			infoLog("Retrying on a fresh spot instance (%d/%d)...", retry, *spotRetries)
+			// A machine that couldn't be destroyed is retried at cleanup
+			if destroyMachine(provisioned, desiredDir) != nil {
+				break
+			}
+			if cost, ok := prices.cost(single, time.Since(provisionedAt)); ok {
+				interruptedCost += cost
+			}
+			remote, provisioned = nil, nil
+			provisionedAt = time.Now()
+			moduleDir, err := stageTerraformModule(desiredDir)
+			if err == nil {
+				provisioned, err = provisionMachine(moduleDir, tfVars, key)
+			}
+			if err != nil {
+				errorLog("%s", err)
+				provisioningOK = false
+				break
+			}
+			remote = provisioned.remote
+			if *ttl > 0 {
+				remote.Deadline = provisionedAt.Add(*ttl)
+			}
+			result.System.InstanceType = provisioned.instanceType
+			result.System.Location = provisioned.location
+			benchmarkOK = runBenchmark(remote, tmpFolder, result)
+		}
+	}
+	if benchmarkOK && *perfMode == "stat" && len(result.Runs) > 0 && result.Runs[0].Counters == nil {
+		infoLog("perf stat reported no hardware counters: most virtualized instances don't expose them, try a metal or dedicated instance type")
+	}
+
+	// Artifacts are downloaded before the machine goes away
+	if len(collectPatterns) > 0 && remote != nil {
+		localDir := filepath.Join(resultsDir, runID)
+		startSpinner("Collecting result artifacts...")
+		files, err := collectArtifacts(remote, remoteBenchmarkDir, collectPatterns, localDir)
 		stopSpinner()
 		if err != nil {
-			errorLog("Failed to copy files to remote machine: %v", err)
-			os.Exit(1)
+			errorLog("Failed to collect artifacts: %v", err)
+		} else if len(files) == 0 {
+			infoLog("No files matched %s", strings.Join(collectPatterns, ", "))
+		} else {
+			successLog("Collected %d file(s) into %s", len(files), localDir)
+			for _, file := range files {
+				debugLog("Collected %s", file)
+			}
 		}
-		
-		// Create a temporary script to run on the remote machine
-		scriptPath := filepath.Join(tmpFolder, "run_benchmark.sh")
-		scriptContent := `#!/bin/bash
-cd /home/` + *sshUser + `/benchmark
-echo "BENCHMARK_START"
-echo "Run 1"
-` + cmdToRun + `
-echo "Run 2"
-` + cmdToRun + `
-echo "Run 3"
-` + cmdToRun + `
-echo "BENCHMARK_END"
-`
-		err = os.WriteFile(scriptPath, []byte(scriptContent), 0755)
-		if err != nil {
-			log.Fatalf("Failed to create benchmark script: %v", err)
+	}
+
+	if benchmarkOK && againstResult != nil {
+		result.Gate = checkRegression(result, againstResult, gateThreshold)
+	} else if benchmarkOK && gateThreshold > 0 {
+		result.Gate = checkComparisons(result, gateThreshold)
+	}
+	result.Failed = !benchmarkOK || !provisioningOK
+	if *reportFormat != "" {
+		path := *reportFile
+		if path == "" {
+			path = defaultReportFile(*reportFormat, runID)
 		}
-		
-		// Run benchmark script on remote machine
-		infoLog("Running benchmark...")
-		sshCmd := exec.Command("ssh", strings.Split(sshKeyOption+" "+*sshUser+"@"+*useExistingMachine+" bash /home/"+*sshUser+"/benchmark/run_benchmark.sh", " ")...)
-		output, err := sshCmd.CombinedOutput()
-		if err != nil {
-			errorLog("Failed to run benchmark on remote machine: %v\nOutput: %s", err, output)
-			os.Exit(1)
+		if err := writeReport(result, *reportFormat, path); err != nil {
+			errorLog("Failed to write the report: %v", err)
+		} else {
+			successLog("Report written to %s", path)
 		}
-		
-		fmt.Println(string(filterOutput(output)))
-		successLog("Benchmark completed successfully")
-		
-		// Clean up temporary folder
-		debugLog("Cleaning up temporary folder %s", tmpFolder)
-		err = os.RemoveAll(tmpFolder)
-		if err != nil {
-			debugLog("Warning: Failed to remove temporary folder: %s. Error: %s", tmpFolder, err)
+	}
+	for _, target := range exports {
+		if err := writeExport(result, target, gateThreshold); err != nil {
+			errorLog("Failed to export the results to %s: %v", target.Path, err)
+		} else {
+			successLog("Results exported to %s", target.Path)
+		}
+	}
+
+	if provisioned != nil {
+		if *pausing && provisioningOK {
+			pauseBeforeDestroy(provisioned)
+		}
+		cleanupOK = destroyMachine(provisioned, desiredDir) == nil
+		lifetime := time.Since(provisionedAt)
+		cost, priced := interruptedCost, true
+		for _, spec := range singles {
+			machineCost, ok := prices.cost(spec, lifetime)
+			cost += machineCost
+			priced = priced && ok
+		}
+		if priced {
+			result.Cost.Actual = cost
+			infoLog("Actual cost: %s for %s (estimated %s)", formatCost(result.Cost.Currency, result.Cost.Actual), lifetime.Round(time.Second), formatCost(result.Cost.Currency, result.Cost.Estimated))
 		}
-		
-		return
 	}
 
-	// Otherwise, use Terraform to provision a new machine
-	terraformDir := getTerraformDir()
+	debugLog("Cleaning up temporary folder %s", fullTempFolder)
+	err = os.RemoveAll(fullTempFolder)
+	if err != nil {
+		errorLog("Failed to remove the temporary folder: %s. Error: %s", fullTempFolder, err)
+		cleanupOK = false
+	}
+	if err := key.remove(); err != nil {
+		errorLog("%v", err)
+		cleanupOK = false
+	}
 
-	startSpinner("Initializing Terraform...")
-	installer := &releases.ExactVersion{
-		Product: product.Terraform,
-		Version: version.Must(version.NewVersion("1.7.5")),
+	// Failed runs are kept too, their output often explains the failure
+	if err := saveHistory(result); err != nil {
+		errorLog("Failed to save the results to the history: %v", err)
+	} else {
+		debugLog("Saved run %s to the history", runID)
+	}
+	switch {
+	case result.Interrupted:
+		os.Exit(exitSpotInterrupted)
+	case !cleanupOK:
+		os.Exit(exitCleanupFailed)
+	case !provisioningOK:
+		os.Exit(exitProvisioningFailed)
+	case !benchmarkOK:
+		os.Exit(exitBenchmarkFailed)
+	case result.Gate != nil && result.Gate.Regressed:
+		os.Exit(exitRegression)
 	}
+	successLog("Benchmark completed successfully")
+}
+
+// runBenchmark uploads the staged folder to the remote machine, runs the
+// generated script and prints the system information and benchmark output,
+// recording both in result. It returns false if the benchmark could not run
+// to completion or one of its runs failed.
+func runBenchmark(remote *remoteHost, stagedFolder string, result *BenchmarkResult) bool {
+	startSpinner("Copying files to remote machine...")
+	output, err := executeBenchmark(remote, stagedFolder)
+	return recordBenchmark(output, err, result)
+}
 
-	execPath, err := installer.Install(context.Background())
+// executeBenchmark uploads the staged folder and runs the generated script,
+// returning its output.
+func executeBenchmark(remote *remoteHost, stagedFolder string) ([]byte, error) {
+	err := remote.Upload(stagedFolder, remoteBenchmarkDir)
+	stopSpinner()
 	if err != nil {
-		stopSpinner()
-		errorLog("Failed to install Terraform: %s", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to copy files to remote machine: %v", err)
 	}
 
-	// Initialize a new tfexec.Terraform object
-	terraform, err := tfexec.NewTerraform(terraformDir, execPath)
+	infoLog("Running benchmark...")
+	output, err := remote.Run("bash " + remoteBenchmarkDir + "/" + runScriptName)
 	if err != nil {
-		stopSpinner()
-		errorLog("Failed to initialize Terraform: %s", err)
-		os.Exit(1)
+		return output, fmt.Errorf("failed to run benchmark on remote machine: %v", err)
 	}
-	stopSpinner()
+	return output, nil
+}
 
-	debugLog("Initializing Terraform in %s", terraformDir)
-	startSpinner("Initializing Terraform providers...")
-	// Initialize with options compatible with Terraform 1.7.5
-	err = terraform.Init(context.Background(), 
-		tfexec.Upgrade(true),
-		tfexec.ForceCopy(true),
-	)
-	stopSpinner()
+// recordBenchmark prints the output of the run script and records it in
+// result, like runBenchmark.
+func recordBenchmark(output []byte, err error, result *BenchmarkResult) bool {
+	// Runs cut short by the end of a spot instance aren't results
+	if interruption, ok := parseSpotInterruption(output); ok {
+		interruption.Attempt = len(result.Interruptions) + 1
+		result.Interruptions = append(result.Interruptions, interruption)
+		result.Interrupted = true
+		errorLog("The spot instance was interrupted (%s), its runs are discarded", describeInterruption(interruption))
+		return false
+	}
+	result.Interrupted = false
+	if !reportSections(parseSections(output)) {
+		return false
+	}
 	if err != nil {
-		errorLog("Failed to initialize Terraform: %s", err)
-		infoLog("Attempting to initialize with alternative options...")
-		
-		// Try again with minimal options
-		startSpinner("Reinitializing Terraform...")
-		err = terraform.Init(context.Background(), tfexec.Upgrade(true))
-		stopSpinner()
-		if err != nil {
-			errorLog("Failed to initialize Terraform: %s", err)
-			fmt.Println("\nTo fix this manually, try running: cd", terraformDir, "&& terraform init -upgrade")
-			os.Exit(1)
+		if len(output) > 0 {
+			errorLog("%v\nOutput: %s", err, output)
+		} else {
+			errorLog("%v", err)
 		}
+		return false
 	}
-	successLog("Terraform initialized successfully")
-
-	// Skip show command which may not work properly before apply
-	// _, err = terraform.Show(context.Background())
-	// if err != nil && !strings.Contains(err.Error(), "state snapshot was created") {
-	//	errorLog("Failed to run Terraform show: %s", err)
-	//	os.Exit(1)
-	// }
 
-	buffer := &bytes.Buffer{}
-	terraform.SetStdout(buffer)
-	terraform.SetStderr(buffer)
+	// Keep what the CLI knows about the machine, the rest comes from the script
+	system := parseSystemInfo(output)
+	system.Provider = result.System.Provider
+	system.InstanceType = result.System.InstanceType
+	system.Location = result.System.Location
+	result.System = system
+	printSystemInfo(system)
+	result.Stabilization = parseStabilizeSteps(output)
+	printStabilizeSteps(result.Stabilization)
 
-	applyVars := []tfexec.ApplyOption{
-		tfexec.Var("benchmark_folder=" + fullTempFolder),
+	fmt.Println(redact(string(filterOutput(output))))
+	result.Runs = parseRuns(output)
+	// The outputs are kept with the result, redacted like the printed one
+	for i := range result.Runs {
+		result.Runs[i].Output = redact(result.Runs[i].Output)
+	}
+	parsePerfCounters(output, result.Runs)
+	printSummaryTable(result.Runs)
+	if variants := variantsOf(result.Runs); len(variants) > 1 {
+		result.Comparisons = compareVariants(result.Runs, variants[0], variants[1:])
+		printComparisons(result.Comparisons)
 	}
 
-	// Provider-specific variables
-	if strings.ToLower(*cloud) == "aws" {
-		applyVars = append(applyVars, tfexec.Var("instance_type="+*instanceType))
-	} else if strings.ToLower(*cloud) == "hetzner" {
-		applyVars = append(applyVars,
-			tfexec.Var("server_type="+*serverType),
-			tfexec.Var("location="+*location),
-		)
-		// Note: Hetzner provider requires HCLOUD_TOKEN env var set externally.
+	// A benchmark command that fails doesn't measure what it should
+	ok := true
+	for _, run := range result.Runs {
+		if run.ExitCode != 0 {
+			errorLog("Run %s exited with code %d", runLabel(run.Index, run.Variant), run.ExitCode)
+			ok = false
+		}
 	}
-	
-	// Set the command to run
-	applyVars = append(applyVars, tfexec.Var("custom_command=echo \"Remote-Output: $("+cmdToRun+")\""))
-	
-	startSpinner("Provisioning machine...")
-	// Run 'terraform apply' in the given directory
-	err = terraform.Apply(context.Background(), applyVars...)
-	stopSpinner()
-	if err != nil {
-		errorLog("Error running terraform apply: %s.\n"+
-			"⚠️  Although, an error occurred while running terraform apply, resources might have been created! Ensure to run:\n"+
-			"cd %s && terraform destroy\n", err, terraformDir)
-		os.Exit(1)
+	return ok
+}
+
+// remoteCommand rewrites the paths of command pointing into folderPath to
+// the folder staged as remoteFolder, and the files copied next to the run
+// script to their staged name.
+func remoteCommand(command, folderPath, remoteFolder string, remappedPaths map[string]string) string {
+	cmdParts := strings.Fields(command)
+	if len(cmdParts) == 0 {
+		return command
 	}
-	successLog("Machine provisioned successfully")
-	fmt.Println(string(filterOutput(buffer.Bytes())))
-	debugLog("Terraform apply completed successfully")
+	// Keep the binary name the same
+	newCmd := []string{cmdParts[0]}
 
-	// TODO: add schedule to destroy feature
-	infoLog("Destroying provisioned machine...")
-	
-	// Create a context with timeout for the destroy operation (longer for Hetzner)
-    destroyTimeout := 3 * time.Minute
-    if strings.ToLower(*cloud) == "hetzner" {
-        destroyTimeout = 10 * time.Minute
-    }
-    destroyCtx, cancel := context.WithTimeout(context.Background(), destroyTimeout)
-    defer cancel()
+	// Adjust paths for other arguments
+	for i := 1; i < len(cmdParts); i++ {
+		part := cmdParts[i]
+		// Remove any quotes
+		part = strings.Trim(part, "'\"")
 
-    startSpinner(fmt.Sprintf("Running terraform destroy (timeout: %v)...", destroyTimeout))
-	
-	// Capture stderr/stdout for debugging
-	destroyBuffer := &bytes.Buffer{}
-	terraform.SetStdout(destroyBuffer)
-	terraform.SetStderr(destroyBuffer)
-	
-	// Run destroy with timeout context
-	// Build destroy vars matching apply
-	destroyVars := []tfexec.DestroyOption{tfexec.Var("benchmark_folder=" + fullTempFolder)}
-	if strings.ToLower(*cloud) == "aws" {
-		destroyVars = append(destroyVars, tfexec.Var("instance_type="+*instanceType))
-	} else if strings.ToLower(*cloud) == "hetzner" {
-		destroyVars = append(destroyVars,
-			tfexec.Var("server_type="+*serverType),
-			tfexec.Var("location="+*location),
-		)
-	}
-	destroyErr := terraform.Destroy(destroyCtx, destroyVars...)
-	stopSpinner()
-	
-	if destroyErr != nil {
-		if errors.Is(destroyErr, context.DeadlineExceeded) {
-			errorLog("Terraform destroy timed out after 3 minutes. Resources may still exist.")
-			fmt.Printf("⚠️  Terraform destroy operation timed out. Resources might still exist! Manually destroy with:\n"+
-				"cd %s && terraform destroy\n", terraformDir)
-		} else {
-			errorLog("Error running terraform destroy: %s", destroyErr)
-			fmt.Printf("⚠️  Although, an error occurred while running terraform destroy, resources might have been created! Ensure to run:\n"+
-				"cd %s && terraform destroy\n", terraformDir)
+		if strings.HasPrefix(part, folderPath) {
+			// If the path starts with the folder path, replace it with the relative path
+			relPath, err := filepath.Rel(folderPath, part)
+			if err == nil {
+				// Include the folder name in the path to maintain the structure
+				newCmd = append(newCmd, filepath.Join(remoteFolder, relPath))
+				continue
+			}
 		}
-		
-		// Output buffer content to help diagnose the issue
-		if debugMode {
-			fmt.Println("Debug output from terraform destroy:")
-			fmt.Println(destroyBuffer.String())
+
+		// Check if this is a file path we've copied
+		absFilePath, err := filepath.Abs(part)
+		if err == nil && remappedPaths[absFilePath] != "" {
+			// Replace with just the file name
+			newCmd = append(newCmd, remappedPaths[absFilePath])
+		} else {
+			// Keep as is
+			newCmd = append(newCmd, part)
 		}
-		
-		// We continue execution to clean up local resources even if destroy failed
-	} else {
-		successLog("Terraform resources destroyed successfully")
 	}
+	return strings.Join(newCmd, " ")
+}
 
-	debugLog("Cleaning up temporary folder %s", fullTempFolder)
-	err = os.RemoveAll(fullTempFolder)
-	if err != nil {
-		errorLog("Failed to remove the temporary folder: %s. Error: %s", fullTempFolder, err)
-		os.Exit(1)
+// destroyMachine tears down a provisioned machine, allowing Hetzner more time
+// as its servers are slower to delete.
+func destroyMachine(m *machine, cloud string) error {
+	// TODO: add schedule to destroy feature
+	infoLog("Destroying provisioned machine...")
+
+	destroyTimeout := 3 * time.Minute
+	if strings.ToLower(cloud) == "hetzner" {
+		destroyTimeout = 10 * time.Minute
 	}
-	successLog("Benchmark completed successfully")
+	// Errors are reported by destroy; we continue to clean up local resources
+	return m.destroy(destroyTimeout)
+}
+
+// newRunID returns a sortable, unique enough identifier for a benchmark run.
+func newRunID() string {
+	suffix := make([]byte, 3)
+	rand.Read(suffix)
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
 }
 
 // Helper function to check if a slice contains a string
@@ -725,7 +1442,12 @@ func dirExists(path string) bool {
 }
 
 func printUsageAndExit() {
-    fmt.Println("Usage: ib-agent-cli [options] [COMMAND] | (--command=\"custom command\")")
+    fmt.Println("Usage: ib-agent-cli [run] [options] [COMMAND] | (--command=\"custom command\")")
+    fmt.Println("       ib-agent-cli compare --rev=REV --rev=REV --folder=PATH [options] --command=\"custom command\"")
+    fmt.Println("       ib-agent-cli bisect --good=REV [--bad=REV] --folder=PATH [options] --command=\"custom command\"")
+    fmt.Println("       ib-agent-cli history [--instance-type=TYPE] [--provider=NAME] [--command=TEXT] [--limit=N] [RUN-ID]")
+    fmt.Println("       ib-agent-cli trend [--metric=NAME] [--instance-type=TYPE] [--provider=NAME] [--command=TEXT] [--limit=N]")
+    fmt.Println("       ib-agent-cli ssh <RUN-ID|NAME>")
     fmt.Println("\nOptions:")
     fmt.Println("  --host=IP               Run on existing machine with this IP address")
     fmt.Println("  --ssh-key=PATH          Path to SSH private key for connecting to existing machine")
@@ -733,10 +1455,78 @@ func printUsageAndExit() {
     fmt.Println("  --folder=PATH           Path to folder containing all dependencies to be copied")
     fmt.Println("  --command=COMMAND       Custom command to run on the instance")
     fmt.Println("  --instance-type=TYPE    AWS instance type to use (default: t2.micro)")
-    fmt.Println("  --cloud=PROVIDER        Cloud provider to use: aws or hetzner (default: aws)")
+    fmt.Println("  --cloud=PROVIDER        Cloud provider to use: aws or hetzner, or aws,hetzner to compare")
+    fmt.Println("                          both in parallel (default: aws)")
     fmt.Println("  --server-type=TYPE      Hetzner server type (for --cloud=hetzner, default: cax11)")
     fmt.Println("  --location=LOC          Hetzner location (for --cloud=hetzner, default: fsn1)")
+    fmt.Println("  --matrix=NAME=V1,V2     Benchmark every combination on machines provisioned in parallel,")
+    fmt.Println("                          NAME being instance-type, server-type or location (repeatable)")
+    fmt.Println("  --max-cost=AMOUNT       Refuse to provision machines whose estimated cost exceeds AMOUNT")
+    fmt.Println("                          (default: $IB_MAX_COST)")
+    fmt.Println("  --yes                   Proceed even when the estimated cost exceeds --max-cost")
+    fmt.Println("  --ttl=DURATION          Maximum lifetime of provisioned machines, e.g. 30m: the benchmark")
+    fmt.Println("                          is stopped when it is reached")
+    fmt.Println("  --allow-cidr=CIDR       CIDR block or IP address allowed to connect to provisioned machines")
+    fmt.Println("                          (repeatable, default: your detected public IP)")
+    fmt.Println("  --open-port=PORT        Additional TCP port to open on provisioned machines, for --allow-cidr")
+    fmt.Println("                          (repeatable)")
+    fmt.Println("  --server-command=CMD    Start a server on its own machine, loaded by --command from a second")
+    fmt.Println("                          client machine that reaches it at $IB_SERVER_IP")
+    fmt.Println("  --server-ready=PORT|URL Port or URL telling when the server accepts connections, polled from")
+    fmt.Println("                          the client, e.g. 8080 or 'http://$IB_SERVER_IP:8080/health'")
+    fmt.Println("  --ready-timeout=DURATION")
+    fmt.Println("                          How long to wait for --server-ready (default: 2m)")
+    fmt.Println("  --client-type=TYPE      Instance or server type of the client (default: the same as the server)")
+    fmt.Println("  --spot                  Benchmark on AWS spot instances: the runs of an interrupted instance")
+    fmt.Println("                          are discarded")
+    fmt.Println("  --spot-max-price=PRICE  Maximum hourly price of the spot instances in USD")
+    fmt.Println("                          (default: the on-demand price)")
+    fmt.Println("  --spot-retries=N        Retry an interrupted benchmark on a fresh spot instance N times")
+    fmt.Println("  --dry-run               Stage the files, plan the machines and print what would run,")
+    fmt.Println("                          without provisioning anything")
+    fmt.Println("  --pause-before-destroy  Open a shell on the provisioned machine once the benchmark is done,")
+    fmt.Println("                          destroying it when the shell exits")
+    fmt.Println("  --terraform-bin=PATH    Terraform binary to run instead of the cached download of Terraform")
+    fmt.Println("                          1.7.5 (default: $IB_TERRAFORM)")
+    fmt.Println("  --runtime=LIST          Runtimes to install before running, e.g. node@24, bun@1.1,")
+    fmt.Println("                          deno@2, python@3.12, go@1.23 or none (default: node@22)")
+    fmt.Println("  --runs=N                Number of measured runs (default: 3)")
+    fmt.Println("  --warmups=N             Number of unmeasured warmup runs (default: 0)")
+    fmt.Println("  --setup=COMMAND         Command to run once before the warmups (repeatable)")
+    fmt.Println("  --setup-script=PATH     Local script to run once before the warmups")
+    fmt.Println("  --teardown=COMMAND      Command to run once after the last run (repeatable)")
+    fmt.Println("  --before-each=COMMAND   Command to run before every measured run (repeatable)")
+    fmt.Println("  --after-each=COMMAND    Command to run after every measured run (repeatable)")
+    fmt.Println("  --env=KEY=VALUE         Environment variable for the remote run (repeatable)")
+    fmt.Println("  --env-file=PATH         File with KEY=VALUE lines for the remote run (repeatable)")
+    fmt.Println("  --secret=KEY            Pass local environment variable KEY as a redacted secret (repeatable)")
+    fmt.Println("  --collect=GLOB          Download matching files from the remote benchmark folder into")
+    fmt.Println("                          results/<run-id>/ before cleanup (repeatable)")
+    fmt.Println("  --stabilize             Reduce noise: performance governor, no turbo, CPU pinning,")
+    fmt.Println("                          dropped page caches between runs and settled load average")
+    fmt.Println("  --disable-aslr          Disable ASLR during the benchmark (implies --stabilize)")
+    fmt.Println("  --perf=MODE             stat: collect hardware counters per run with perf stat")
+    fmt.Println("                          record: record profiles and download flamegraphs")
+    fmt.Println("  --baseline-command=CMD  Command to compare against, interleaved with the benchmark runs")
+    fmt.Println("  --baseline-folder=PATH  Folder replacing --folder for the baseline runs")
+    fmt.Println("  --fail-if-slower-than=PERCENT")
+    fmt.Println("                          Exit with code 2 if the wall time regressed by more than PERCENT")
+    fmt.Println("                          against --against, or against the baseline when comparing")
+    fmt.Println("  --against=ID|PATH       History run ID or result file to compare with --fail-if-slower-than")
+    fmt.Println("  --report=FORMAT         Write a markdown or html report of the results")
+    fmt.Println("  --report-file=PATH      Path of the report (default: results/<run-id>/report.md or .html)")
+    fmt.Println("  --export=FORMAT:PATH    Export the results as csv (one row per run and metric) or junit")
+    fmt.Println("                          (repeatable)")
     fmt.Println("  --debug                 Enable debug logging")
+    fmt.Println("\nCompare options:")
+    fmt.Println("  --rev=REV               Git revision of --folder to compare, the first one being the baseline (repeatable)")
+    fmt.Println("  --rev-setup=COMMAND     Command to run once in every exported revision before the warmups (repeatable)")
+    fmt.Println("\nBisect options:")
+    fmt.Println("  --good=REV              Git revision of --folder without the regression")
+    fmt.Println("  --bad=REV               Git revision of --folder with the regression (default: HEAD)")
+    fmt.Println("  --metric=NAME           Metric to bisect, e.g. wall, max_rss or cycles (default: wall)")
+    fmt.Println("  --threshold=PERCENT     Change of the metric counted as a regression (default: 5%)")
+    fmt.Println("  --rev-setup=COMMAND     Command to run once in every exported revision before the warmups (repeatable)")
     fmt.Println("\nHetzner examples:")
     fmt.Println("  export HCLOUD_TOKEN=\"<your_hcloud_api_token>\"")
     fmt.Println("  ib-agent-cli --cloud=hetzner --server-type=cax11 --location=fsn1 --command='node script.js'")
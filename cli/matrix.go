@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// matrixVariables maps the names accepted by --matrix to the Terraform
+// variable they set, for each cloud they apply to.
+var matrixVariables = map[string]map[string]string{
+	"instance-type": {"aws": "instance_type"},
+	"server-type":   {"hetzner": "server_type"},
+	"location":      {"hetzner": "location"},
+}
+
+// matrixAxis is a --matrix value, e.g. instance-type=t3.small,c6i.large.
+type matrixAxis struct {
+	Name   string
+	Values []string
+}
+
+// parseMatrixAxis reads a --matrix value.
+func parseMatrixAxis(value string) (matrixAxis, error) {
+	name, values, ok := strings.Cut(value, "=")
+	if _, known := matrixVariables[name]; !ok || !known {
+		return matrixAxis{}, fmt.Errorf("%q should be NAME=VALUE,VALUE with NAME one of instance-type, server-type or location", value)
+	}
+	axis := matrixAxis{Name: name}
+	for _, value := range strings.Split(values, ",") {
+		value = strings.TrimSpace(value)
+		if value != "" && !contains(axis.Values, value) {
+			axis.Values = append(axis.Values, value)
+		}
+	}
+	if len(axis.Values) == 0 {
+		return matrixAxis{}, fmt.Errorf("%q has no values", value)
+	}
+	return axis, nil
+}
+
+// machineSpec is a machine to provision with the Terraform module of its
+// cloud.
+type machineSpec struct {
+	// Name identifies the machine among the others of the matrix, and names
+	// the variant of its runs
+	Name  string
+	Cloud string
+	Vars  map[string]string
+}
+
+// machineVars returns the Terraform variables of a single machine on cloud.
+func machineVars(cloud, instanceType, serverType, location string) map[string]string {
+	if cloud == "hetzner" {
+		// Note: Hetzner provider requires HCLOUD_TOKEN env var set externally.
+		return map[string]string{"server_type": serverType, "location": location}
+	}
+	return map[string]string{"instance_type": instanceType}
+}
+
+// parseClouds reads --cloud, one provider or several separated by commas.
+func parseClouds(value string) ([]string, error) {
+	var clouds []string
+	for _, cloud := range strings.Split(strings.ToLower(value), ",") {
+		cloud = strings.TrimSpace(cloud)
+		if cloud != "aws" && cloud != "hetzner" {
+			return nil, fmt.Errorf("unsupported cloud provider %q, use aws or hetzner", cloud)
+		}
+		if !contains(clouds, cloud) {
+			clouds = append(clouds, cloud)
+		}
+	}
+	return clouds, nil
+}
+
+// matrixSpecs returns the machines of every cloud, one per combination of
+// the values of the axes applying to it, the other variables keeping their
+// value in base. Machines are named after the values of the axes, prefixed
+// with their cloud when there are several.
+func matrixSpecs(clouds []string, base map[string]map[string]string, axes []matrixAxis) ([]machineSpec, error) {
+	for _, axis := range axes {
+		applies := false
+		for _, cloud := range clouds {
+			_, ok := matrixVariables[axis.Name][cloud]
+			applies = applies || ok
+		}
+		if !applies {
+			return nil, fmt.Errorf("%s doesn't apply to --cloud=%s", axis.Name, strings.Join(clouds, ","))
+		}
+	}
+
+	var all []machineSpec
+	for _, cloud := range clouds {
+		specs := []machineSpec{{Cloud: cloud, Vars: base[cloud]}}
+		for _, axis := range axes {
+			variable, ok := matrixVariables[axis.Name][cloud]
+			if !ok {
+				continue
+			}
+			var combined []machineSpec
+			for _, spec := range specs {
+				for _, value := range axis.Values {
+					vars := make(map[string]string)
+					for name, value := range spec.Vars {
+						vars[name] = value
+					}
+					vars[variable] = value
+					name := value
+					if spec.Name != "" {
+						name = spec.Name + "/" + value
+					}
+					combined = append(combined, machineSpec{Name: name, Cloud: cloud, Vars: vars})
+				}
+			}
+			specs = combined
+		}
+		for _, spec := range specs {
+			if len(clouds) > 1 {
+				// Without axes of its own, a provider's machine is named after its type
+				if spec.Name == "" {
+					spec.Name = valueOr(spec.Vars["instance_type"], spec.Vars["server_type"])
+				}
+				spec.Name = cloud + "/" + spec.Name
+			}
+			all = append(all, spec)
+		}
+	}
+	return all, nil
+}
+
+// terraformVars returns vars as KEY=VALUE, with the name of the resources
+// of the machine.
+func terraformVars(vars map[string]string, name string) []string {
+	tfVars := []string{"name=" + name}
+	for _, key := range sortedKeys(vars) {
+		tfVars = append(tfVars, key+"="+vars[key])
+	}
+	return tfVars
+}
+
+// matrixOptions are shared by every machine of a matrix.
+type matrixOptions struct {
+	StagedFolder string
+	RunID        string
+	Collect      []string
+	// Prices is nil when no cost is estimated
+	Prices      *priceTable
+	TTL         time.Duration
+	SpotRetries int
+	// Key is the SSH key of every machine
+	Key *sshKey
+}
+
+// matrixMachine is the outcome of the benchmark on one machine of a matrix.
+type matrixMachine struct {
+	spec         machineSpec
+	provisioned  *machine
+	provisionErr error
+	output       []byte
+	runErr       error
+	cleanupOK    bool
+	// lifetimes go from provisioning to destroy, one per instance when
+	// interrupted spot instances were replaced
+	lifetimes []time.Duration
+	// interruptions of the spot instances that were replaced
+	interruptions []SpotInterruption
+}
+
+// runMatrix provisions every machine of specs concurrently, runs the staged
+// benchmark on each of them and destroys them. Their runs are recorded in
+// result under the name of their machine, and compared with those of the
+// first one. It returns whether every benchmark, every provisioning and every
+// cleanup succeeded.
+func runMatrix(specs []machineSpec, opts matrixOptions, result *BenchmarkResult) (bool, bool, bool) {
+	var names []string
+	for _, spec := range specs {
+		names = append(names, spec.Name)
+	}
+	infoLog("Provisioning %d machines in parallel: %s", len(specs), strings.Join(names, ", "))
+	var clouds []string
+	for _, spec := range specs {
+		if !contains(clouds, spec.Cloud) {
+			clouds = append(clouds, spec.Cloud)
+		}
+	}
+	result.System.Provider = strings.Join(clouds, ",")
+	result.System.InstanceType = strings.Join(names, ", ")
+
+	machines := make([]*matrixMachine, len(specs))
+	var wait sync.WaitGroup
+	spinnersDisabled = true
+	for i, spec := range specs {
+		machines[i] = &matrixMachine{spec: spec, cleanupOK: true}
+		wait.Add(1)
+		go func(m *matrixMachine, index int) {
+			defer wait.Done()
+			m.run(opts, fmt.Sprintf("ib-%s-%d", opts.RunID, index+1))
+		}(machines[i], i)
+	}
+	wait.Wait()
+	spinnersDisabled = false
+
+	benchmarkOK, provisioningOK, cleanupOK := true, true, true
+	result.Machines = make(map[string]SystemInfo)
+	for _, m := range machines {
+		infoLog("── %s ──", m.spec.Name)
+		cleanupOK = cleanupOK && m.cleanupOK
+		if m.provisionErr != nil {
+			errorLog("%s", m.provisionErr)
+			provisioningOK = false
+			for _, interruption := range m.interruptions {
+				interruption.Machine = m.spec.Name
+				result.Interruptions = append(result.Interruptions, interruption)
+			}
+			continue
+		}
+
+		machineResult := &BenchmarkResult{System: SystemInfo{
+			Provider:     m.spec.Cloud,
+			InstanceType: m.provisioned.instanceType,
+			Location:     m.provisioned.location,
+		}, Interruptions: m.interruptions}
+		if !recordBenchmark(m.output, m.runErr, machineResult) {
+			benchmarkOK = false
+		}
+		for _, interruption := range machineResult.Interruptions {
+			interruption.Machine = m.spec.Name
+			result.Interruptions = append(result.Interruptions, interruption)
+		}
+		result.Interrupted = result.Interrupted || machineResult.Interrupted
+		result.Machines[m.spec.Name] = machineResult.System
+		for _, run := range machineResult.Runs {
+			run.Variant = m.spec.Name
+			result.Runs = append(result.Runs, run)
+		}
+	}
+
+	infoLog("── All machines ──")
+	printSummaryTable(result.Runs)
+	if variants := variantsOf(result.Runs); len(variants) > 1 {
+		result.Comparisons = compareVariants(result.Runs, variants[0], variants[1:])
+		printComparisons(result.Comparisons)
+	}
+	if opts.Prices != nil && result.Cost != nil {
+		result.Cost.Machines = make(map[string]float64)
+		for _, m := range machines {
+			var cost float64
+			var lifetime time.Duration
+			priced := len(m.lifetimes) > 0
+			for _, instanceLifetime := range m.lifetimes {
+				instanceCost, ok := opts.Prices.cost(m.spec, instanceLifetime)
+				cost += instanceCost
+				lifetime += instanceLifetime
+				priced = priced && ok
+			}
+			if priced {
+				result.Cost.Machines[m.spec.Name] = cost
+				result.Cost.Actual += cost
+				infoLog("Cost of %s: %s for %s", m.spec.Name, formatCost(result.Cost.Currency, cost), lifetime.Round(time.Second))
+			}
+		}
+		infoLog("Actual cost: %s (estimated %s)", formatCost(result.Cost.Currency, result.Cost.Actual), formatCost(result.Cost.Currency, result.Cost.Estimated))
+	}
+	return benchmarkOK, provisioningOK, cleanupOK
+}
+
+// run provisions the machine in its own copy of the Terraform module, named
+// name, runs the benchmark, collects the artifacts and destroys it. An
+// interrupted spot instance is replaced up to opts.SpotRetries times.
+func (m *matrixMachine) run(opts matrixOptions, name string) {
+	for retry := 0; m.attempt(opts, name) && retry < opts.SpotRetries; retry++ {
+		interruption, ok := parseSpotInterruption(m.output)
+		if !ok {
+			return
+		}
+		interruption.Attempt = retry + 1
+		m.interruptions = append(m.interruptions, interruption)
+		infoLog("%s: the spot instance was interrupted (%s), retrying on a fresh one", m.spec.Name, describeInterruption(interruption))
+	}
+}
+
+// attempt runs the benchmark on a new instance of the machine. It returns
+// false when the machine could not be provisioned or destroyed.
+func (m *matrixMachine) attempt(opts matrixOptions, name string) bool {
+	moduleDir, err := stageTerraformModule(m.spec.Cloud)
+	if err != nil {
+		m.provisionErr = fmt.Errorf("%s: failed to prepare the Terraform module: %v", m.spec.Name, err)
+		return false
+	}
+	infoLog("Provisioning %s...", m.spec.Name)
+	started := time.Now()
+	m.provisioned, err = provisionMachine(moduleDir, terraformVars(m.spec.Vars, name), opts.Key)
+	if err != nil {
+		m.provisionErr = fmt.Errorf("%s: %v", m.spec.Name, err)
+		// The state of a failed apply is kept, it might have created resources
+		if m.provisioned != nil {
+			m.cleanupOK = destroyMachine(m.provisioned, m.spec.Cloud) == nil
+		}
+		m.lifetimes = append(m.lifetimes, time.Since(started))
+		return false
+	}
+	if opts.TTL > 0 {
+		m.provisioned.remote.Deadline = started.Add(opts.TTL)
+	}
+
+	infoLog("Running the benchmark on %s...", m.spec.Name)
+	m.output, m.runErr = executeBenchmark(m.provisioned.remote, opts.StagedFolder)
+	_, interrupted := parseSpotInterruption(m.output)
+	if len(opts.Collect) > 0 && m.output != nil && !interrupted {
+		localDir := filepath.Join(resultsDir, opts.RunID, variantName(m.spec.Name))
+		files, err := collectArtifacts(m.provisioned.remote, remoteBenchmarkDir, opts.Collect, localDir)
+		if err != nil {
+			errorLog("%s: failed to collect artifacts: %v", m.spec.Name, err)
+		} else if len(files) > 0 {
+			successLog("%s: collected %d file(s) into %s", m.spec.Name, len(files), localDir)
+		}
+	}
+
+	m.cleanupOK = destroyMachine(m.provisioned, m.spec.Cloud) == nil
+	m.lifetimes = append(m.lifetimes, time.Since(started))
+	return m.cleanupOK
+}
+
+// machineNames returns the names of the machines of a matrix result, those
+// with runs first, in the order they ran.
+func machineNames(result *BenchmarkResult) []string {
+	var names []string
+	for _, variant := range variantsOf(result.Runs) {
+		if _, ok := result.Machines[variant]; ok {
+			names = append(names, variant)
+		}
+	}
+	var others []string
+	for name := range result.Machines {
+		if !contains(names, name) {
+			others = append(others, name)
+		}
+	}
+	sort.Strings(others)
+	return append(names, others...)
+}
+
+// systemOf returns the machine the runs of variant measured.
+func systemOf(result *BenchmarkResult, variant string) SystemInfo {
+	if system, ok := result.Machines[variant]; ok {
+		return system
+	}
+	return result.System
+}
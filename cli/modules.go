@@ -0,0 +1,46 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// modules holds the Terraform module of every provider, so that an installed
+// binary doesn't depend on the source checkout it was built from. The lock
+// files pin the provider versions the modules were tested with.
+//
+//go:embed modules/*/*.tf modules/*/.terraform.lock.hcl
+var modules embed.FS
+
+// stageTerraformModule extracts the Terraform module of cloud into a new
+// directory, so that every machine gets its own state.
+func stageTerraformModule(cloud string) (string, error) {
+	files, err := fs.Glob(modules, path.Join("modules", cloud, "*.tf"))
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("no Terraform module for %s", cloud)
+	}
+	files = append(files, path.Join("modules", cloud, ".terraform.lock.hcl"))
+	dir, err := os.MkdirTemp("", "ib-terraform-")
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		data, err := modules.ReadFile(file)
+		if err == nil {
+			err = os.WriteFile(filepath.Join(dir, path.Base(file)), data, 0644)
+		}
+		if err != nil {
+			os.RemoveAll(dir)
+			return "", err
+		}
+	}
+	debugLog("Extracted the Terraform module of %s into %s", cloud, dir)
+	return dir, nil
+}
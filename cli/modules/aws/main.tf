@@ -0,0 +1,162 @@
+terraform {
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = "~> 4.0"
+    }
+  }
+}
+
+provider "aws" {
+  region = "us-east-1"
+}
+
+# Graviton instance types like c7g.large need an arm64 image, the others an
+# amd64 one
+data "aws_ec2_instance_type" "server" {
+  instance_type = var.instance_type
+}
+
+data "aws_ec2_instance_type" "client" {
+  instance_type = coalesce(var.client_type, var.instance_type)
+}
+
+locals {
+  server_architecture = contains(data.aws_ec2_instance_type.server.supported_architectures, "arm64") ? "arm64" : "amd64"
+  client_architecture = contains(data.aws_ec2_instance_type.client.supported_architectures, "arm64") ? "arm64" : "amd64"
+}
+
+data "aws_ami" "ubuntu" {
+  for_each    = toset(["amd64", "arm64"])
+  most_recent = true
+
+  filter {
+    name   = "name"
+    values = ["ubuntu/images/hvm-ssd/ubuntu-jammy-22.04-${each.key}-server-*"]
+  }
+
+  filter {
+    name   = "virtualization-type"
+    values = ["hvm"]
+  }
+
+  owners = ["099720109477"] # Canonical
+}
+
+resource "aws_key_pair" "generated_key" {
+  key_name   = var.name
+  public_key = var.public_key
+}
+
+locals {
+  allowed_ipv4 = [for cidr in var.allowed_cidrs : cidr if !strcontains(cidr, ":")]
+  allowed_ipv6 = [for cidr in var.allowed_cidrs : cidr if strcontains(cidr, ":")]
+}
+
+# SSH and the --open-port ports only accept connections from allowed_cidrs
+resource "aws_security_group" "security" {
+  name = var.name
+
+  ingress {
+    cidr_blocks      = local.allowed_ipv4
+    ipv6_cidr_blocks = local.allowed_ipv6
+    from_port        = 22
+    to_port          = 22
+    protocol         = "tcp"
+  }
+
+  dynamic "ingress" {
+    for_each = var.open_ports
+    content {
+      cidr_blocks      = local.allowed_ipv4
+      ipv6_cidr_blocks = local.allowed_ipv6
+      from_port        = ingress.value
+      to_port          = ingress.value
+      protocol         = "tcp"
+    }
+  }
+
+  # The client of --server-command reaches the server on its private IP
+  ingress {
+    from_port = 0
+    to_port   = 0
+    protocol  = -1
+    self      = true
+  }
+
+  egress {
+    from_port   = 0
+    to_port     = 0
+    protocol    = -1
+    cidr_blocks = ["0.0.0.0/0"]
+  }
+}
+
+resource "aws_instance" "example" {
+  ami                         = data.aws_ami.ubuntu[local.server_architecture].id
+  instance_type               = var.instance_type
+  key_name                    = aws_key_pair.generated_key.key_name
+  vpc_security_group_ids      = [aws_security_group.security.id]
+  associate_public_ip_address = true
+
+  # With --spot, the instance is terminated rather than restarted when AWS
+  # reclaims it: the CLI detects the notice and can retry on a fresh one
+  dynamic "instance_market_options" {
+    for_each = var.spot ? [1] : []
+    content {
+      market_type = "spot"
+      spot_options {
+        max_price                      = var.spot_max_price
+        spot_instance_type             = "one-time"
+        instance_interruption_behavior = "terminate"
+      }
+    }
+  }
+
+  tags = {
+    Name = var.name
+  }
+
+  # The CLI waits until the instance accepts SSH connections and cloud-init
+  # is done, then uploads the benchmark folder and runs it over SSH
+}
+
+# With --server-command, the load generator runs on a second instance next to
+# the server under test
+resource "aws_instance" "client" {
+  count                       = var.client ? 1 : 0
+  ami                         = data.aws_ami.ubuntu[local.client_architecture].id
+  instance_type               = coalesce(var.client_type, var.instance_type)
+  availability_zone           = aws_instance.example.availability_zone
+  key_name                    = aws_key_pair.generated_key.key_name
+  vpc_security_group_ids      = [aws_security_group.security.id]
+  associate_public_ip_address = true
+
+  tags = {
+    Name = "${var.name}-client"
+  }
+}
+
+output "host" {
+  value = aws_instance.example.public_ip
+}
+
+output "ssh_user" {
+  value = "ubuntu"
+}
+
+output "instance_type" {
+  value = aws_instance.example.instance_type
+}
+
+output "location" {
+  value = aws_instance.example.availability_zone
+}
+
+output "client_host" {
+  value = var.client ? aws_instance.client[0].public_ip : ""
+}
+
+output "server_private_ip" {
+  value = var.client ? aws_instance.example.private_ip : ""
+}
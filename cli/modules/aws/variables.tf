@@ -0,0 +1,53 @@
+variable "instance_type" {
+  type        = string
+  description = "The instance type to use for the instance."
+}
+
+variable "name" {
+  type        = string
+  description = "Name of the instance, its key pair and security group, unique per benchmark machine."
+  default     = "instant-bench"
+}
+
+variable "spot" {
+  type        = bool
+  description = "Request a spot instance instead of an on-demand one."
+  default     = false
+}
+
+variable "spot_max_price" {
+  type        = string
+  description = "Maximum hourly price of the spot instance in USD, the on-demand price when null."
+  default     = null
+}
+
+variable "allowed_cidrs" {
+  type        = list(string)
+  description = "CIDR blocks allowed to connect over SSH and to open_ports. The CLI passes the public IP of the caller."
+  default     = ["0.0.0.0/0"]
+}
+
+variable "open_ports" {
+  type        = list(number)
+  description = "Additional TCP ports reachable from allowed_cidrs, e.g. for a load generator."
+  default     = []
+}
+
+variable "client" {
+  type        = bool
+  description = "Provision a second machine running the load generator, on the same private network."
+  default     = false
+}
+
+variable "client_type" {
+  type        = string
+  description = "Instance type of the client machine, the same as the server when empty."
+  default     = ""
+}
+
+variable "public_key" {
+  type        = string
+  description = "OpenSSH public key of the key pair the CLI generates for every run. The private key never reaches Terraform."
+  # Set by the CLI, empty so that a manual terraform destroy doesn't ask for it
+  default     = ""
+}
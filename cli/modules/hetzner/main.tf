@@ -0,0 +1,132 @@
+terraform {
+  required_providers {
+    hcloud = {
+      source  = "hetznercloud/hcloud"
+      version = "~> 1.48"
+    }
+  }
+}
+
+# The hcloud provider reads the token from the environment variable HCLOUD_TOKEN by default.
+# Alternatively, you can set: token = var.hcloud_token
+provider "hcloud" {}
+
+# Upload the public key generated by the CLI to Hetzner Cloud
+resource "hcloud_ssh_key" "generated_key" {
+  name       = var.name
+  public_key = var.public_key
+}
+
+# SSH and the --open-port ports only accept connections from allowed_cidrs
+resource "hcloud_firewall" "firewall" {
+  name = var.name
+
+  rule {
+    direction  = "in"
+    protocol   = "tcp"
+    port       = "22"
+    source_ips = var.allowed_cidrs
+  }
+
+  dynamic "rule" {
+    for_each = var.open_ports
+    content {
+      direction  = "in"
+      protocol   = "tcp"
+      port       = tostring(rule.value)
+      source_ips = var.allowed_cidrs
+    }
+  }
+}
+
+# With --server-command, the server and the client talk over a private network
+locals {
+  server_private_ip = "10.0.1.2"
+  client_private_ip = "10.0.1.3"
+  network_zones = {
+    fsn1 = "eu-central"
+    nbg1 = "eu-central"
+    hel1 = "eu-central"
+    ash  = "us-east"
+    hil  = "us-west"
+    sin  = "ap-southeast"
+  }
+}
+
+resource "hcloud_network" "private" {
+  count    = var.client ? 1 : 0
+  name     = var.name
+  ip_range = "10.0.0.0/16"
+}
+
+resource "hcloud_network_subnet" "private" {
+  count        = var.client ? 1 : 0
+  network_id   = hcloud_network.private[0].id
+  type         = "cloud"
+  network_zone = lookup(local.network_zones, var.location, "eu-central")
+  ip_range     = "10.0.1.0/24"
+}
+
+# Create a server
+resource "hcloud_server" "server" {
+  name         = var.name
+  server_type  = var.server_type
+  image        = "ubuntu-22.04"
+  location     = var.location
+  ssh_keys     = [hcloud_ssh_key.generated_key.id]
+  firewall_ids = [hcloud_firewall.firewall.id]
+
+  dynamic "network" {
+    for_each = var.client ? [1] : []
+    content {
+      network_id = hcloud_network.private[0].id
+      ip         = local.server_private_ip
+    }
+  }
+
+  depends_on = [hcloud_network_subnet.private]
+
+  # Wait for IPv4; hcloud gives public IPv4 by default
+}
+
+# The load generator of --server-command
+resource "hcloud_server" "client" {
+  count        = var.client ? 1 : 0
+  name         = "${var.name}-client"
+  server_type  = coalesce(var.client_type, var.server_type)
+  image        = "ubuntu-22.04"
+  location     = var.location
+  ssh_keys     = [hcloud_ssh_key.generated_key.id]
+  firewall_ids = [hcloud_firewall.firewall.id]
+
+  network {
+    network_id = hcloud_network.private[0].id
+    ip         = local.client_private_ip
+  }
+
+  depends_on = [hcloud_network_subnet.private]
+}
+
+output "host" {
+  value = hcloud_server.server.ipv4_address
+}
+
+output "ssh_user" {
+  value = "root"
+}
+
+output "instance_type" {
+  value = hcloud_server.server.server_type
+}
+
+output "location" {
+  value = hcloud_server.server.location
+}
+
+output "client_host" {
+  value = var.client ? hcloud_server.client[0].ipv4_address : ""
+}
+
+output "server_private_ip" {
+  value = var.client ? local.server_private_ip : ""
+}
@@ -0,0 +1,48 @@
+variable "server_type" {
+  type        = string
+  description = "Hetzner Cloud server type (e.g., cx22, cpx11, cax11)."
+  default     = "cax11"
+}
+
+variable "location" {
+  type        = string
+  description = "Hetzner Cloud location (e.g., fsn1, hel1, nbg1)."
+  default     = "fsn1"
+}
+
+variable "name" {
+  type        = string
+  description = "Name of the server, its SSH key and firewall, unique per benchmark machine."
+  default     = "instant-bench"
+}
+
+variable "allowed_cidrs" {
+  type        = list(string)
+  description = "CIDR blocks allowed to connect over SSH and to open_ports. The CLI passes the public IP of the caller."
+  default     = ["0.0.0.0/0"]
+}
+
+variable "open_ports" {
+  type        = list(number)
+  description = "Additional TCP ports reachable from allowed_cidrs, e.g. for a load generator."
+  default     = []
+}
+
+variable "client" {
+  type        = bool
+  description = "Provision a second machine running the load generator, on the same private network."
+  default     = false
+}
+
+variable "client_type" {
+  type        = string
+  description = "Server type of the client machine, the same as the server when empty."
+  default     = ""
+}
+
+variable "public_key" {
+  type        = string
+  description = "OpenSSH public key of the key pair the CLI generates for every run. The private key never reaches Terraform."
+  # Set by the CLI, empty so that a manual terraform destroy doesn't ask for it
+  default     = ""
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStageTerraformModule(t *testing.T) {
+	for _, cloud := range []string{"aws", "hetzner"} {
+		dir, err := stageTerraformModule(cloud)
+		if err != nil {
+			t.Fatalf("stageTerraformModule(%q): %v", cloud, err)
+		}
+		defer os.RemoveAll(dir)
+		for _, name := range []string{"main.tf", "variables.tf", ".terraform.lock.hcl"} {
+			if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+				t.Errorf("%s module: %v", cloud, err)
+			}
+		}
+	}
+	if _, err := stageTerraformModule("gcp"); err == nil {
+		t.Errorf("stageTerraformModule(%q) succeeded, want an error", "gcp")
+	}
+}
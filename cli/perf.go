@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// perfEvents are the hardware counters collected by --perf=stat.
+var perfEvents = []string{"cycles", "instructions", "cache-misses", "branch-misses"}
+
+// perfOutputDir holds the folded stacks and flamegraphs of --perf=record,
+// downloaded with the other artifacts.
+const perfOutputDir = "ib-perf"
+
+// flameGraphURL is where flamegraph.pl and stackcollapse-perf.pl are fetched.
+const flameGraphURL = "https://raw.githubusercontent.com/brendangregg/FlameGraph/master"
+
+// validatePerfMode checks the --perf value.
+func validatePerfMode(mode string) error {
+	switch mode {
+	case "", "stat", "record":
+		return nil
+	}
+	return fmt.Errorf("unsupported mode %q, use stat or record", mode)
+}
+
+// perfSetupLines installs perf when missing and lets unprivileged users read
+// the counters.
+func perfSetupLines() []string {
+	return []string{
+		`if ! perf --version > /dev/null 2>&1; then $APT update && $APT install linux-tools-common linux-tools-generic "linux-tools-$(uname -r)"; fi`,
+		`perf --version`,
+		`{ ib_write -1 /proc/sys/kernel/perf_event_paranoid; ib_write 0 /proc/sys/kernel/kptr_restrict; true; }`,
+	}
+}
+
+// perfPrefix returns the command measured runs are prefixed with.
+func perfPrefix(mode string) string {
+	switch mode {
+	case "stat":
+		return "perf stat -x , -e " + strings.Join(perfEvents, ",") + " -o .ib-perf-stat --"
+	case "record":
+		return "perf record -q -F 999 -g -o .ib-perf.data --"
+	}
+	return ""
+}
+
+// perfAfterRunLines reports the counters of a run as "IB_PERF_STAT <run key>
+// event=value...", or keeps its profile for the flamegraphs.
+func perfAfterRunLines(mode string, key string) []string {
+	switch mode {
+	case "stat":
+		return []string{
+			fmt.Sprintf(`echo "IB_PERF_STAT %s $(awk -F , '$1 ~ /^[0-9.]+$/ { printf "%%s=%%s ", $3, $1 }' .ib-perf-stat 2>/dev/null)"`, key),
+		}
+	case "record":
+		return []string{
+			fmt.Sprintf("mkdir -p .ib-perf-record && mv .ib-perf.data .ib-perf-record/run-%s.data 2>/dev/null", strings.ReplaceAll(key, ":", "-")),
+		}
+	}
+	return nil
+}
+
+// flamegraphLines turns every recorded profile into folded stacks and an SVG
+// flamegraph in perfOutputDir.
+func flamegraphLines() []string {
+	return []string{
+		"mkdir -p .ib-flamegraph " + perfOutputDir,
+		"curl -fsSL -o .ib-flamegraph/stackcollapse-perf.pl " + flameGraphURL + "/stackcollapse-perf.pl",
+		"curl -fsSL -o .ib-flamegraph/flamegraph.pl " + flameGraphURL + "/flamegraph.pl",
+		`for f in .ib-perf-record/run-*.data; do ` +
+			`n=$(basename "$f" .data); ` +
+			`perf script -i "$f" 2>/dev/null | perl .ib-flamegraph/stackcollapse-perf.pl > "` + perfOutputDir + `/$n.folded" && ` +
+			`perl .ib-flamegraph/flamegraph.pl --title "$n" "` + perfOutputDir + `/$n.folded" > "` + perfOutputDir + `/$n.svg"; ` +
+			`done`,
+	}
+}
+
+// parsePerfCounters attaches the IB_PERF_STAT counters to runs.
+func parsePerfCounters(output []byte, runs []RunResult) {
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		text := strings.TrimSpace(string(stripRemoteExecPrefix(line)))
+		rest, ok := strings.CutPrefix(text, "IB_PERF_STAT ")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		counters := make(map[string]float64)
+		for _, field := range fields[1:] {
+			event, value, ok := strings.Cut(field, "=")
+			if !ok {
+				continue
+			}
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			counters[normalizePerfEvent(event)] = number
+		}
+		for i := range runs {
+			if runKey(runs[i].Index, runs[i].Variant) == fields[0] && len(counters) > 0 {
+				runs[i].Counters = counters
+			}
+		}
+	}
+}
+
+// normalizePerfEvent maps "cpu_core/cache-misses/" or "cycles:u" to the
+// metric name, e.g. "cache_misses".
+func normalizePerfEvent(event string) string {
+	event = strings.TrimSuffix(event, "/")
+	if i := strings.LastIndex(event, "/"); i >= 0 {
+		event = event[i+1:]
+	}
+	event, _, _ = strings.Cut(event, ":")
+	return strings.ReplaceAll(event, "-", "_")
+}
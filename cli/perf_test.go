@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizePerfEvent(t *testing.T) {
+	tests := map[string]string{
+		"cycles":                  "cycles",
+		"cycles:u":                "cycles",
+		"cache-misses":            "cache_misses",
+		"cpu_core/cache-misses/":  "cache_misses",
+		"cpu_atom/branch-misses/": "branch_misses",
+	}
+	for event, want := range tests {
+		if got := normalizePerfEvent(event); got != want {
+			t.Errorf("normalizePerfEvent(%q) = %q, want %q", event, got, want)
+		}
+	}
+}
+
+func TestParsePerfCounters(t *testing.T) {
+	runs := []RunResult{{Index: 1}, {Index: 1, Variant: "fast"}, {Index: 2}}
+	output := []byte(`IB_PERF_STAT 1 cycles:u=1000 instructions=2500 cpu_core/cache-misses/=12
+aws_instance.example (remote-exec): IB_PERF_STAT 1:fast cycles=500 branch-misses=not-counted
+IB_PERF_STAT 2 cycles=<not-supported>
+`)
+	parsePerfCounters(output, runs)
+
+	want := []map[string]float64{
+		{"cycles": 1000, "instructions": 2500, "cache_misses": 12},
+		{"cycles": 500},
+		nil,
+	}
+	for i, run := range runs {
+		if !reflect.DeepEqual(run.Counters, want[i]) {
+			t.Errorf("run %s counters = %v, want %v", runKey(run.Index, run.Variant), run.Counters, want[i])
+		}
+	}
+}
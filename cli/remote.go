@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// remoteBenchmarkDir is where staged files are uploaded, relative to the home
+// directory of the SSH user.
+const remoteBenchmarkDir = "benchmark"
+
+// remoteHost is a machine reachable over SSH: either an existing machine given
+// with --host, or one provisioned by Terraform.
+type remoteHost struct {
+	Host    string
+	User    string
+	KeyPath string
+	// Ephemeral hosts are freshly provisioned machines whose host keys are
+	// unknown and whose IPs get reused, so host key checking is skipped.
+	Ephemeral bool
+	// Deadline, when set, stops the commands of Run still running when it
+	// passes, for --ttl
+	Deadline time.Time
+}
+
+func (r *remoteHost) target() string {
+	return r.User + "@" + r.Host
+}
+
+// options returns the flags shared by ssh and scp.
+func (r *remoteHost) options() []string {
+	var opts []string
+	if r.KeyPath != "" {
+		opts = append(opts, "-i", r.KeyPath)
+	}
+	if r.Ephemeral {
+		opts = append(opts,
+			"-o", "StrictHostKeyChecking=no",
+			"-o", "UserKnownHostsFile=/dev/null",
+			"-o", "LogLevel=ERROR",
+			"-o", "BatchMode=yes",
+			"-o", "ConnectTimeout=10",
+		)
+	}
+	return opts
+}
+
+// command builds an ssh invocation running command on the remote machine.
+func (r *remoteHost) command(command string) *exec.Cmd {
+	args := append(r.options(), r.target(), command)
+	debugLog("Running on %s: %s", r.Host, command)
+	return exec.Command("ssh", args...)
+}
+
+// Run executes command on the remote machine and returns its combined output.
+func (r *remoteHost) Run(command string) ([]byte, error) {
+	if r.Deadline.IsZero() {
+		return r.command(command).CombinedOutput()
+	}
+	remaining := time.Until(r.Deadline)
+	if remaining <= 0 {
+		return nil, fmt.Errorf("the --ttl of the machine expired")
+	}
+	// timeout stops the whole process group of the command
+	output, err := r.command(fmt.Sprintf("timeout %d bash -c %s", int(remaining.Seconds())+1, shellQuote(command))).CombinedOutput()
+	if err != nil && time.Now().After(r.Deadline) {
+		return output, fmt.Errorf("stopped at the end of the --ttl: %v", err)
+	}
+	return output, err
+}
+
+// machineReadyTimeout bounds the wait for a provisioned machine to boot.
+const machineReadyTimeout = 5 * time.Minute
+
+// waitReady waits until the machine accepts SSH connections and cloud-init is
+// done, for at most timeout.
+func (r *remoteHost) waitReady(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		output, err := r.Run("cloud-init status --wait > /dev/null 2>&1 || true")
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s is not reachable over SSH after %v: %v %s", r.Host, timeout, err, strings.TrimSpace(string(output)))
+		}
+		debugLog("Waiting for SSH on %s: %v", r.Host, err)
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// Upload copies the content of localDir into remoteDir, creating it first.
+func (r *remoteHost) Upload(localDir, remoteDir string) error {
+	createDirCmd := r.command("mkdir -p " + remoteDir)
+	createDirCmd.Stdout = os.Stdout
+	createDirCmd.Stderr = os.Stderr
+	if err := createDirCmd.Run(); err != nil {
+		return err
+	}
+
+	files, err := filepath.Glob(filepath.Join(localDir, "*"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	args := append(r.options(), "-r")
+	args = append(args, files...)
+	args = append(args, r.target()+":"+strings.TrimSuffix(remoteDir, "/")+"/")
+	scpCmd := exec.Command("scp", args...)
+	scpCmd.Stdout = os.Stdout
+	scpCmd.Stderr = os.Stderr
+	return scpCmd.Run()
+}
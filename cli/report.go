@@ -0,0 +1,404 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// validateReportFormat checks the --report value.
+func validateReportFormat(format string) error {
+	switch format {
+	case "", "markdown", "html":
+		return nil
+	}
+	return fmt.Errorf("unsupported format %q, use markdown or html", format)
+}
+
+// defaultReportFile is where the report goes without --report-file.
+func defaultReportFile(format, runID string) string {
+	extension := ".md"
+	if format == "html" {
+		extension = ".html"
+	}
+	return filepath.Join(resultsDir, runID, "report"+extension)
+}
+
+// writeReport renders result in format into path.
+func writeReport(result *BenchmarkResult, format, path string) error {
+	content := renderMarkdown(result)
+	if format == "html" {
+		content = renderHTML(result)
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+// reportCell is a table cell. Tone marks changes as "better", "worse" or
+// "neutral", shown with colored arrows.
+type reportCell struct {
+	Text string
+	Tone string
+}
+
+type reportTable struct {
+	Header []string
+	Rows   [][]reportCell
+}
+
+func (t *reportTable) add(cells ...string) {
+	row := make([]reportCell, len(cells))
+	for i, cell := range cells {
+		row[i] = reportCell{Text: cell}
+	}
+	t.Rows = append(t.Rows, row)
+}
+
+// reportSection is a titled part of the report, rendered the same way in
+// Markdown and HTML.
+type reportSection struct {
+	Title  string
+	Lines  []string
+	Tables []reportTable
+	// Chart adds the distribution of the wall times to the HTML report
+	Chart bool
+}
+
+// reportLayout lays out result: outcome first, then the machine and
+// options, then the numbers.
+func reportLayout(result *BenchmarkResult) []reportSection {
+	var sections []reportSection
+
+	overview := reportSection{Title: "Benchmark " + result.ID}
+	overview.Lines = append(overview.Lines, "Command: `"+result.Command+"`")
+	if result.BaselineCommand != "" {
+		overview.Lines = append(overview.Lines, "Baseline command: `"+result.BaselineCommand+"`")
+	}
+	if result.ServerCommand != "" {
+		overview.Lines = append(overview.Lines, "Server command: `"+result.ServerCommand+"`")
+	}
+	if result.GitCommit != "" {
+		overview.Lines = append(overview.Lines, "Commit: `"+result.GitCommit+"`")
+	}
+	for _, variant := range variantsOf(result.Runs) {
+		if commit, ok := result.Revisions[variant]; ok {
+			overview.Lines = append(overview.Lines, "Revision "+variant+": `"+commit+"`")
+		}
+	}
+	overview.Lines = append(overview.Lines, "Started: "+result.StartedAt.UTC().Format("2006-01-02 15:04:05 UTC"))
+	if result.Cost != nil {
+		overview.Lines = append(overview.Lines, "Cost: "+costLine(result.Cost))
+	}
+	if len(result.Interruptions) > 0 {
+		overview.Lines = append(overview.Lines, "Spot interruptions: "+interruptionsLine(result))
+	}
+	if result.Interrupted {
+		overview.Lines = append(overview.Lines, "❌ The spot instance was interrupted, its runs were discarded")
+	} else if result.Failed {
+		overview.Lines = append(overview.Lines, "❌ The benchmark failed")
+	}
+	if gate := result.Gate; gate != nil {
+		verdict := "✅ No regression"
+		if gate.Regressed {
+			verdict = "❌ Regression"
+		}
+		overview.Lines = append(overview.Lines, fmt.Sprintf("%s: wall time %s against %s (threshold %s, p=%s)",
+			verdict, formatChange(gate.Wall.Change), gate.Against, formatChange(gate.Threshold), formatPValue(gate.Wall.PValue)))
+	}
+	sections = append(sections, overview)
+
+	if bisect := result.Bisect; bisect != nil {
+		section := reportSection{Title: "Bisect"}
+		if bisect.FirstBad != "" {
+			section.Lines = append(section.Lines, fmt.Sprintf("First commit regressing %s beyond %s: `%s`", bisect.Metric, formatChange(bisect.Threshold), bisect.FirstBad))
+		} else {
+			section.Lines = append(section.Lines, fmt.Sprintf("%s did not regress %s beyond %s", bisect.Bad, bisect.Metric, formatChange(bisect.Threshold)))
+		}
+		table := reportTable{Header: []string{"Commit", "Subject", "Change", "p", "Regressed"}}
+		for _, step := range bisect.Steps {
+			table.Rows = append(table.Rows, []reportCell{
+				{Text: step.Commit},
+				{Text: step.Subject},
+				changeCell(bisect.Metric, step.Change, math.Abs(step.Change) > bisect.Threshold),
+				{Text: formatPValue(step.PValue)},
+				{Text: yesNo(step.Regressed)},
+			})
+		}
+		section.Tables = append(section.Tables, table)
+		sections = append(sections, section)
+	}
+
+	if len(result.Machines) > 0 {
+		section := reportSection{Title: "Systems"}
+		for _, name := range machineNames(result) {
+			system := reportTable{Header: []string{name, ""}}
+			for _, line := range systemInfoLines(result.Machines[name]) {
+				system.add(line[0], line[1])
+			}
+			if cost, ok := result.Cost.machine(name); ok {
+				system.add("Cost", formatCost(result.Cost.Currency, cost))
+			}
+			section.Tables = append(section.Tables, system)
+		}
+		sections = append(sections, section)
+	} else {
+		system := reportTable{Header: []string{"", ""}}
+		for _, line := range systemInfoLines(result.System) {
+			system.add(line[0], line[1])
+		}
+		sections = append(sections, reportSection{Title: "System", Tables: []reportTable{system}})
+	}
+
+	if len(result.Parameters) > 0 {
+		parameters := reportTable{Header: []string{"Option", "Value"}}
+		for _, name := range sortedKeys(result.Parameters) {
+			parameters.add("--"+name, result.Parameters[name])
+		}
+		sections = append(sections, reportSection{Title: "Parameters", Tables: []reportTable{parameters}})
+	}
+
+	if len(result.Comparisons) > 0 {
+		section := reportSection{Title: "Comparison"}
+		var current *reportTable
+		for _, comparison := range result.Comparisons {
+			if current == nil || current.Header[2] != comparison.Candidate {
+				section.Tables = append(section.Tables, reportTable{Header: []string{"Metric", comparison.Baseline, comparison.Candidate, "Change", "p (Mann-Whitney)", "p (Welch)"}})
+				current = &section.Tables[len(section.Tables)-1]
+			}
+			metric, _ := findMetric(comparison.Metric)
+			current.Rows = append(current.Rows, []reportCell{
+				{Text: metric.header},
+				{Text: metric.format(comparison.BaselineMean)},
+				{Text: metric.format(comparison.CandidateMean)},
+				changeCell(comparison.Metric, comparison.Change, comparison.Significant),
+				{Text: formatPValue(comparison.PValue)},
+				{Text: formatPValue(comparison.WelchPValue)},
+			})
+		}
+		sections = append(sections, section)
+	}
+
+	if len(result.Runs) > 0 {
+		columns := summaryColumns(result.Runs)
+		variants := variantsOf(result.Runs)
+
+		summary := reportTable{Header: []string{"Variant", "Metric", "Mean", "Median", "Std dev", "Min", "Max"}}
+		for _, variant := range variants {
+			for _, metric := range columns {
+				values, ok := metricValues(runsOf(result.Runs, variant), metric)
+				if !ok {
+					continue
+				}
+				lowest, highest := math.Inf(1), math.Inf(-1)
+				for _, value := range values {
+					lowest, highest = min(lowest, value), max(highest, value)
+				}
+				summary.add(valueOr(variant, "-"), metric.header,
+					metric.format(mean(values)), metric.format(median(values)), metric.format(stddev(values)),
+					metric.format(lowest), metric.format(highest))
+			}
+		}
+		sections = append(sections, reportSection{Title: "Summary", Tables: []reportTable{summary}, Chart: true})
+
+		runs := reportTable{Header: []string{"Run", "Variant", "Exit"}}
+		for _, metric := range columns {
+			runs.Header = append(runs.Header, metric.header)
+		}
+		for _, variant := range variants {
+			for _, run := range runsOf(result.Runs, variant) {
+				row := []string{strconv.Itoa(run.Index), valueOr(variant, "-"), strconv.Itoa(run.ExitCode)}
+				for _, metric := range columns {
+					row = append(row, metric.format(metric.value(run)))
+				}
+				runs.add(row...)
+			}
+		}
+		sections = append(sections, reportSection{Title: "Runs", Tables: []reportTable{runs}})
+	}
+	return sections
+}
+
+// changeCell shows a relative change with an arrow, colored when it is
+// significant.
+func changeCell(metric string, change float64, significant bool) reportCell {
+	arrow := "▲"
+	if change < 0 {
+		arrow = "▼"
+	}
+	tone := "neutral"
+	if significant {
+		tone = "better"
+		if regression(metric, change) > 0 {
+			tone = "worse"
+		}
+	}
+	return reportCell{Text: arrow + " " + formatChange(change), Tone: tone}
+}
+
+// renderMarkdown renders result as GitHub flavored Markdown, for PR comments.
+func renderMarkdown(result *BenchmarkResult) string {
+	var b strings.Builder
+	for i, section := range reportLayout(result) {
+		level := "###"
+		if i == 0 {
+			level = "##"
+		}
+		fmt.Fprintf(&b, "%s %s\n\n", level, section.Title)
+		for _, line := range section.Lines {
+			b.WriteString(line + "  \n")
+		}
+		if len(section.Lines) > 0 {
+			b.WriteString("\n")
+		}
+		for _, table := range section.Tables {
+			b.WriteString("| " + strings.Join(markdownEscape(table.Header), " | ") + " |\n")
+			b.WriteString(strings.Repeat("|---", len(table.Header)) + "|\n")
+			for _, row := range table.Rows {
+				var cells []string
+				for _, cell := range row {
+					cells = append(cells, markdownCell(cell))
+				}
+				b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+func markdownCell(cell reportCell) string {
+	text := markdownEscape([]string{cell.Text})[0]
+	switch cell.Tone {
+	case "better":
+		return "🟢 " + text
+	case "worse":
+		return "🔴 " + text
+	case "neutral":
+		return "⚪ " + text
+	}
+	return text
+}
+
+func markdownEscape(cells []string) []string {
+	escaped := make([]string, len(cells))
+	for i, cell := range cells {
+		escaped[i] = strings.ReplaceAll(cell, "|", `\|`)
+	}
+	return escaped
+}
+
+// renderHTML renders result as a standalone HTML page.
+func renderHTML(result *BenchmarkResult) string {
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&b, "<title>Benchmark %s</title>\n", html.EscapeString(result.ID))
+	b.WriteString(`<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em auto; max-width: 1100px; color: #1f2328; }
+table { border-collapse: collapse; margin: 0.5em 0 1.5em; }
+th, td { border: 1px solid #d0d7de; padding: 4px 10px; text-align: left; }
+th { background: #f6f8fa; }
+code { background: #f6f8fa; padding: 1px 4px; border-radius: 4px; }
+.better { color: #1a7f37; font-weight: bold; }
+.worse { color: #cf222e; font-weight: bold; }
+.neutral { color: #656d76; }
+</style>
+</head>
+<body>
+`)
+	for i, section := range reportLayout(result) {
+		tag := "h2"
+		if i == 0 {
+			tag = "h1"
+		}
+		fmt.Fprintf(&b, "<%s>%s</%s>\n", tag, html.EscapeString(section.Title), tag)
+		for _, line := range section.Lines {
+			fmt.Fprintf(&b, "<p>%s</p>\n", htmlLine(line))
+		}
+		for _, table := range section.Tables {
+			b.WriteString("<table>\n<tr>")
+			for _, header := range table.Header {
+				fmt.Fprintf(&b, "<th>%s</th>", html.EscapeString(header))
+			}
+			b.WriteString("</tr>\n")
+			for _, row := range table.Rows {
+				b.WriteString("<tr>")
+				for _, cell := range row {
+					if cell.Tone != "" {
+						fmt.Fprintf(&b, `<td class="%s">%s</td>`, cell.Tone, html.EscapeString(cell.Text))
+					} else {
+						fmt.Fprintf(&b, "<td>%s</td>", html.EscapeString(cell.Text))
+					}
+				}
+				b.WriteString("</tr>\n")
+			}
+			b.WriteString("</table>\n")
+		}
+		if section.Chart {
+			b.WriteString(distributionChart(result.Runs))
+		}
+	}
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
+
+// htmlLine escapes line and turns its `code` spans into <code> elements.
+func htmlLine(line string) string {
+	parts := strings.Split(line, "`")
+	for i := range parts {
+		parts[i] = html.EscapeString(parts[i])
+		if i%2 == 1 {
+			parts[i] = "<code>" + parts[i] + "</code>"
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// distributionChart draws the wall time of every run as a dot, one row per
+// variant, with its mean as a vertical bar.
+func distributionChart(runs []RunResult) string {
+	const width, labelWidth, rowHeight, padding = 800.0, 140.0, 36.0, 20.0
+	wall, _ := findMetric("wall")
+	variants := variantsOf(runs)
+
+	lowest, highest := math.Inf(1), math.Inf(-1)
+	for _, run := range runs {
+		lowest, highest = min(lowest, run.Wall), max(highest, run.Wall)
+	}
+	if highest == lowest {
+		lowest, highest = lowest*0.9, highest*1.1+1e-9
+	}
+	x := func(value float64) float64 {
+		return labelWidth + (value-lowest)/(highest-lowest)*(width-labelWidth-padding)
+	}
+
+	height := float64(len(variants))*rowHeight + 2*padding
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f" font-family="sans-serif" font-size="12">`+"\n", width, height)
+	fmt.Fprintf(&b, `<text x="%.0f" y="14" fill="#656d76">Wall time per run</text>`+"\n", labelWidth)
+	for i, variant := range variants {
+		y := padding + float64(i)*rowHeight + rowHeight/2
+		fmt.Fprintf(&b, `<text x="0" y="%.1f" dominant-baseline="middle">%s</text>`+"\n", y, html.EscapeString(valueOr(variant, "runs")))
+		fmt.Fprintf(&b, `<line x1="%.1f" x2="%.1f" y1="%.1f" y2="%.1f" stroke="#d0d7de"/>`+"\n", labelWidth, width-padding, y, y)
+		variantRuns := runsOf(runs, variant)
+		for _, run := range variantRuns {
+			fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="5" fill="#0969da" fill-opacity="0.6"><title>Run %d: %s</title></circle>`+"\n",
+				x(run.Wall), y, run.Index, wall.format(run.Wall))
+		}
+		average := meanOf(variantRuns, wall)
+		fmt.Fprintf(&b, `<line x1="%.1f" x2="%.1f" y1="%.1f" y2="%.1f" stroke="#cf222e" stroke-width="2"><title>mean: %s</title></line>`+"\n",
+			x(average), x(average), y-rowHeight/3, y+rowHeight/3, wall.format(average))
+	}
+	bottom := height - 4
+	fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" fill="#656d76">%s</text>`+"\n", labelWidth, bottom, wall.format(lowest))
+	fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" fill="#656d76" text-anchor="end">%s</text>`+"\n", width-padding, bottom, wall.format(highest))
+	b.WriteString("</svg>\n")
+	return b.String()
+}
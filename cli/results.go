@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BenchmarkResult is the structured record of a benchmark run, built from the
+// output of the run script.
+type BenchmarkResult struct {
+	ID        string    `json:"id"`
+	StartedAt time.Time `json:"started_at"`
+	Command   string    `json:"command"`
+	// GitCommit is the commit of --folder, or of the current directory, with
+	// a "-dirty" suffix for uncommitted changes
+	GitCommit string `json:"git_commit,omitempty"`
+	// Parameters holds the options given on the command line
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// Failed is set when the benchmark could not run to completion
+	Failed bool `json:"failed,omitempty"`
+	// Interrupted is set when the spot instance of the last attempt was
+	// interrupted, whose runs were discarded. Interruptions lists every
+	// notice, including those of the attempts that were retried.
+	Interrupted   bool               `json:"interrupted,omitempty"`
+	Interruptions []SpotInterruption `json:"interruptions,omitempty"`
+	System        SystemInfo         `json:"system"`
+	Runs          []RunResult        `json:"runs"`
+	// Machines describes the machines of a --matrix run, keyed by the
+	// variant naming their runs
+	Machines map[string]SystemInfo `json:"machines,omitempty"`
+	// BaselineCommand is the command the runs of the "baseline" variant
+	// measured, and Comparisons their difference with the other variants
+	BaselineCommand string             `json:"baseline_command,omitempty"`
+	Comparisons     []MetricComparison `json:"comparisons,omitempty"`
+	// ServerCommand ran on the server machine while Command loaded it from
+	// the client, and ServerOutput is what it printed
+	ServerCommand string `json:"server_command,omitempty"`
+	ServerOutput  string `json:"server_output,omitempty"`
+	// Revisions maps the variants of the compare command to their commit
+	Revisions map[string]string `json:"revisions,omitempty"`
+	// Bisect is the outcome of the bisect command
+	Bisect *BisectResult `json:"bisect,omitempty"`
+	// Gate is the outcome of --fail-if-slower-than
+	Gate *GateResult `json:"gate,omitempty"`
+	// Cost is the estimated and actual cost of the provisioned machines
+	Cost *CostResult `json:"cost,omitempty"`
+	// Stabilization lists the --stabilize steps and whether they applied
+	Stabilization []StabilizeStep `json:"stabilization,omitempty"`
+}
+
+// RunResult is a single measured run. Resource usage comes from GNU time and
+// is left at zero when it isn't available on the remote machine.
+type RunResult struct {
+	Index int `json:"index"`
+	// Variant names the command of the run when several are compared
+	Variant  string  `json:"variant,omitempty"`
+	Output   string  `json:"output"`
+	ExitCode int     `json:"exit_code"`
+	Wall     float64 `json:"wall_seconds"`
+
+	User                   float64 `json:"user_seconds"`
+	Sys                    float64 `json:"sys_seconds"`
+	MaxRSSKB               int64   `json:"max_rss_kb"`
+	VoluntaryCtxSwitches   int64   `json:"voluntary_ctx_switches"`
+	InvoluntaryCtxSwitches int64   `json:"involuntary_ctx_switches"`
+	BlockInputOps          int64   `json:"block_input_ops"`
+	BlockOutputOps         int64   `json:"block_output_ops"`
+	HasResourceUsage       bool    `json:"has_resource_usage"`
+
+	// Counters holds the hardware counters collected with --perf=stat
+	Counters map[string]float64 `json:"counters,omitempty"`
+}
+
+// runMetric is a numeric value measured for every run, used by the summary
+// table and anywhere a metric is selected by name.
+type runMetric struct {
+	name   string
+	header string
+	format func(float64) string
+	value  func(RunResult) float64
+	// available reports whether the run measured this metric at all
+	available func(RunResult) bool
+}
+
+var runMetrics = []runMetric{
+	{"wall", "Wall", formatSeconds, func(r RunResult) float64 { return r.Wall }, always},
+	{"user", "User", formatSeconds, func(r RunResult) float64 { return r.User }, hasResourceUsage},
+	{"sys", "Sys", formatSeconds, func(r RunResult) float64 { return r.Sys }, hasResourceUsage},
+	{"max_rss", "Max RSS", formatKB, func(r RunResult) float64 { return float64(r.MaxRSSKB) }, hasResourceUsage},
+	{"voluntary_cs", "Vol CS", formatCount, func(r RunResult) float64 { return float64(r.VoluntaryCtxSwitches) }, hasResourceUsage},
+	{"involuntary_cs", "Invol CS", formatCount, func(r RunResult) float64 { return float64(r.InvoluntaryCtxSwitches) }, hasResourceUsage},
+	{"block_in", "Block in", formatCount, func(r RunResult) float64 { return float64(r.BlockInputOps) }, hasResourceUsage},
+	{"block_out", "Block out", formatCount, func(r RunResult) float64 { return float64(r.BlockOutputOps) }, hasResourceUsage},
+	counterMetric("cycles", "Cycles"),
+	counterMetric("instructions", "Instructions"),
+	{"ipc", "IPC", formatRatio, func(r RunResult) float64 {
+		if r.Counters["cycles"] == 0 {
+			return 0
+		}
+		return r.Counters["instructions"] / r.Counters["cycles"]
+	}, func(r RunResult) bool { return r.Counters["cycles"] > 0 && r.Counters["instructions"] > 0 }},
+	counterMetric("cache_misses", "Cache misses"),
+	counterMetric("branch_misses", "Branch misses"),
+}
+
+func counterMetric(name, header string) runMetric {
+	return runMetric{name, header, formatCount,
+		func(r RunResult) float64 { return r.Counters[name] },
+		func(r RunResult) bool { _, ok := r.Counters[name]; return ok },
+	}
+}
+
+func always(RunResult) bool { return true }
+
+func hasResourceUsage(r RunResult) bool { return r.HasResourceUsage }
+
+// findMetric looks up a metric by name.
+func findMetric(name string) (runMetric, bool) {
+	for _, metric := range runMetrics {
+		if metric.name == name {
+			return metric, true
+		}
+	}
+	return runMetric{}, false
+}
+
+func metricNames() []string {
+	names := make([]string, len(runMetrics))
+	for i, metric := range runMetrics {
+		names[i] = metric.name
+	}
+	return names
+}
+
+func formatSeconds(v float64) string {
+	if v < 1 {
+		return fmt.Sprintf("%.1fms", v*1000)
+	}
+	return fmt.Sprintf("%.3fs", v)
+}
+
+func formatKB(v float64) string {
+	return fmt.Sprintf("%.1f MiB", v/1024)
+}
+
+func formatCount(v float64) string {
+	if v != float64(int64(v)) {
+		return strconv.FormatFloat(v, 'f', 1, 64)
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+func formatRatio(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// runLabel is printed after "Run " before every measured run: its index,
+// followed by the variant in brackets when comparing commands.
+func runLabel(index int, variant string) string {
+	if variant == "" {
+		return strconv.Itoa(index)
+	}
+	return fmt.Sprintf("%d [%s]", index, variant)
+}
+
+// runKey identifies a run in the IB_RUN_END and IB_PERF_STAT lines.
+func runKey(index int, variant string) string {
+	if variant == "" {
+		return strconv.Itoa(index)
+	}
+	return fmt.Sprintf("%d:%s", index, variant)
+}
+
+// runHeader matches the lines printed with runLabel.
+var runHeader = regexp.MustCompile(`^Run (\d+)(?: \[(\S+)\])?$`)
+
+// parseRuns splits the benchmark output into runs, using the "Run N" lines
+// printed by the run script, and attaches the IB_RUN_END measurements.
+func parseRuns(output []byte) []RunResult {
+	var runs []RunResult
+	for _, line := range bytes.Split(filterOutput(output), []byte("\n")) {
+		text := strings.TrimRight(string(line), "\r")
+		if match := runHeader.FindStringSubmatch(text); match != nil {
+			index, _ := strconv.Atoi(match[1])
+			runs = append(runs, RunResult{Index: index, Variant: match[2]})
+			continue
+		}
+		if len(runs) > 0 {
+			runs[len(runs)-1].Output += text + "\n"
+		}
+	}
+
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		text := strings.TrimSpace(string(stripRemoteExecPrefix(line)))
+		rest, ok := strings.CutPrefix(text, "IB_RUN_END ")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) < 3 {
+			continue
+		}
+		for i := range runs {
+			if runKey(runs[i].Index, runs[i].Variant) == fields[0] {
+				applyRunMeasurements(&runs[i], fields[1:])
+			}
+		}
+	}
+	return runs
+}
+
+// applyRunMeasurements reads "<exit> <wall µs> [user sys rss vcs ics in out]".
+func applyRunMeasurements(run *RunResult, fields []string) {
+	run.ExitCode, _ = strconv.Atoi(fields[0])
+	micros, _ := strconv.ParseInt(fields[1], 10, 64)
+	run.Wall = float64(micros) / 1e6
+
+	usage := fields[2:]
+	if len(usage) < 7 {
+		return
+	}
+	run.HasResourceUsage = true
+	run.User, _ = strconv.ParseFloat(usage[0], 64)
+	run.Sys, _ = strconv.ParseFloat(usage[1], 64)
+	run.MaxRSSKB, _ = strconv.ParseInt(usage[2], 10, 64)
+	run.VoluntaryCtxSwitches, _ = strconv.ParseInt(usage[3], 10, 64)
+	run.InvoluntaryCtxSwitches, _ = strconv.ParseInt(usage[4], 10, 64)
+	run.BlockInputOps, _ = strconv.ParseInt(usage[5], 10, 64)
+	run.BlockOutputOps, _ = strconv.ParseInt(usage[6], 10, 64)
+}
+
+// printSummaryTable prints one row per run with its wall time and every other
+// metric measured for the first run, followed by the mean of every column.
+// Compared variants get their own rows and mean.
+func printSummaryTable(runs []RunResult) {
+	if len(runs) == 0 {
+		return
+	}
+
+	columns := summaryColumns(runs)
+	variants := variantsOf(runs)
+	header := []string{"Run", "Exit"}
+	if len(variants) > 1 {
+		header = []string{"Run", "Variant", "Exit"}
+	}
+	for _, metric := range columns {
+		header = append(header, metric.header)
+	}
+	rows := [][]string{header}
+	for _, variant := range variants {
+		variantRuns := runsOf(runs, variant)
+		for _, run := range variantRuns {
+			row := []string{strconv.Itoa(run.Index), strconv.Itoa(run.ExitCode)}
+			if len(variants) > 1 {
+				row = []string{strconv.Itoa(run.Index), variant, strconv.Itoa(run.ExitCode)}
+			}
+			for _, metric := range columns {
+				row = append(row, metric.format(metric.value(run)))
+			}
+			rows = append(rows, row)
+		}
+
+		mean := []string{"mean", ""}
+		if len(variants) > 1 {
+			mean = []string{"mean", variant, ""}
+		}
+		for _, metric := range columns {
+			mean = append(mean, metric.format(meanOf(variantRuns, metric)))
+		}
+		rows = append(rows, mean)
+	}
+	printTable(rows)
+}
+
+// summaryColumns returns the metrics measured for the first run.
+func summaryColumns(runs []RunResult) []runMetric {
+	var columns []runMetric
+	for _, metric := range runMetrics {
+		if metric.available(runs[0]) {
+			columns = append(columns, metric)
+		}
+	}
+	return columns
+}
+
+// variantsOf lists the variants of runs in the order they first ran.
+func variantsOf(runs []RunResult) []string {
+	var variants []string
+	for _, run := range runs {
+		if !contains(variants, run.Variant) {
+			variants = append(variants, run.Variant)
+		}
+	}
+	return variants
+}
+
+// runsOf returns the runs of variant.
+func runsOf(runs []RunResult, variant string) []RunResult {
+	var matching []RunResult
+	for _, run := range runs {
+		if run.Variant == variant {
+			matching = append(matching, run)
+		}
+	}
+	return matching
+}
+
+func meanOf(runs []RunResult, metric runMetric) float64 {
+	total := 0.0
+	for _, run := range runs {
+		total += metric.value(run)
+	}
+	return total / float64(len(runs))
+}
+
+// printTable prints rows as left aligned columns, the first row being the
+// header.
+func printTable(rows [][]string) {
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+	for r, row := range rows {
+		var line strings.Builder
+		for i, cell := range row {
+			fmt.Fprintf(&line, "%-*s  ", widths[i], cell)
+		}
+		if r == 0 {
+			infoLog("%s", strings.TrimRight(line.String(), " "))
+		} else {
+			fmt.Println(strings.TrimRight(line.String(), " "))
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRuns(t *testing.T) {
+	output := []byte(`installing dependencies
+BENCHMARK_START
+IB_SECTION_START before-each
+warming the cache
+IB_SECTION_END
+Run 1 [baseline]
+hello
+IB_RUN_END 1:baseline 0 1500000 1.20 0.10 20480 3 4 0 8
+Run 1 [candidate]
+aws_instance.example (remote-exec): world
+IB_RUN_END 1:candidate 1 250000
+Run 2
+done
+BENCHMARK_END
+IB_RUN_END 2 0 42
+`)
+
+	want := []RunResult{
+		{
+			Index: 1, Variant: "baseline", Output: "hello\n", Wall: 1.5,
+			User: 1.2, Sys: 0.1, MaxRSSKB: 20480, VoluntaryCtxSwitches: 3, InvoluntaryCtxSwitches: 4,
+			BlockInputOps: 0, BlockOutputOps: 8, HasResourceUsage: true,
+		},
+		{Index: 1, Variant: "candidate", Output: "world\n", ExitCode: 1, Wall: 0.25},
+		// filterOutput ends with a newline, kept by the last run
+		{Index: 2, Output: "done\n\n", Wall: 0.000042},
+	}
+	if got := parseRuns(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseRuns() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestParseRunsWithoutMarkers(t *testing.T) {
+	if got := parseRuns([]byte("Run 1\nnot measured\n")); got != nil {
+		t.Errorf("parseRuns() = %+v, want no runs outside BENCHMARK_START", got)
+	}
+}
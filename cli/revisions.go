@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// revisionsDir holds one folder per revision measured by the compare
+// command, each laid out like the staged folder of a regular run.
+const revisionsDir = ".ib-revs"
+
+// revision is a git revision of the --folder project exported for compare.
+type revision struct {
+	// Rev is the revision as given to --rev, Name its variant name
+	Rev    string
+	Name   string
+	Commit string
+	// Dir is the staged folder of the revision, relative to the run script
+	Dir string
+}
+
+var unsafeVariantChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// variantName turns a revision into a name usable in the run script markers
+// and log file names, e.g. "feature/x" into "feature-x".
+func variantName(rev string) string {
+	name := strings.Trim(unsafeVariantChars.ReplaceAllString(rev, "-"), "-")
+	if name == "" {
+		return "rev"
+	}
+	return name
+}
+
+// exportRevisions writes the content of folder at every rev into its own
+// directory of stagedFolder, named after the folder like a regular run.
+func exportRevisions(folder string, revs []string, stagedFolder string) ([]revision, error) {
+	var revisions []revision
+	for _, rev := range revs {
+		name := variantName(rev)
+		for _, other := range revisions {
+			if other.Name == name {
+				return nil, fmt.Errorf("revisions %q and %q have the same name %q", other.Rev, rev, name)
+			}
+		}
+
+		commit, err := exec.Command("git", "-C", folder, "rev-parse", "--short", "--verify", rev+"^{commit}").Output()
+		if err != nil {
+			return nil, fmt.Errorf("unknown revision %q in %s", rev, folder)
+		}
+
+		dir := filepath.ToSlash(filepath.Join(revisionsDir, name))
+		if err := exportRevision(folder, rev, filepath.Join(stagedFolder, dir, filepath.Base(folder))); err != nil {
+			return nil, fmt.Errorf("failed to export %s: %v", rev, err)
+		}
+		revisions = append(revisions, revision{Rev: rev, Name: name, Commit: strings.TrimSpace(string(commit)), Dir: dir})
+	}
+	return revisions, nil
+}
+
+// exportRevision extracts the tree of folder at rev into dest with git archive,
+// so only tracked files are staged. Symlinks are kept as long as they point
+// inside dest, see extractTar.
+func exportRevision(folder, rev, dest string) error {
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return err
+	}
+	// git archive limits itself to the current directory, so the tree of
+	// folder is exported from the top of the repository
+	paths, err := exec.Command("git", "-C", folder, "rev-parse", "--show-toplevel", "--show-prefix").Output()
+	if err != nil {
+		return fmt.Errorf("%s is not in a git repository", folder)
+	}
+	top, prefix, _ := strings.Cut(strings.TrimRight(string(paths), "\n"), "\n")
+	archive := exec.Command("git", "-C", top, "archive", "--format=tar", rev+":"+prefix)
+	var stderr strings.Builder
+	archive.Stderr = &stderr
+	stdout, err := archive.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := archive.Start(); err != nil {
+		return err
+	}
+	_, extractErr := extractTar(stdout, dest)
+	if err := archive.Wait(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return extractErr
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportRevisionsKeepsSymlinks(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repo := t.TempDir()
+	folder := filepath.Join(repo, "app")
+	if err := os.MkdirAll(folder, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(folder, "bench.sh"), []byte("echo bench\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("bench.sh", filepath.Join(folder, "current")); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "bench"},
+	} {
+		if output, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v %s", args, err, output)
+		}
+	}
+
+	staged := t.TempDir()
+	revisions, err := exportRevisions(folder, []string{"HEAD"}, staged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported := filepath.Join(staged, revisions[0].Dir, "app")
+	if target, err := os.Readlink(filepath.Join(exported, "current")); err != nil || target != "bench.sh" {
+		t.Errorf("current links to %q (%v), want bench.sh", target, err)
+	}
+	if info, err := os.Stat(filepath.Join(exported, "bench.sh")); err != nil || info.Mode().Perm()&0100 == 0 {
+		t.Errorf("bench.sh is not executable after the export (%v)", err)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// runtimeRecipe describes how to install a language runtime on the remote
+// machine before the benchmark runs. The returned shell lines are executed
+// inside the generated run script, so anything they export (PATH, etc.) is
+// visible to the benchmark command.
+type runtimeRecipe struct {
+	defaultVersion string
+	setup          func(version string) []string
+	// versionCommand prints the installed version, reported with the system
+	// information.
+	versionCommand string
+}
+
+var runtimeRecipes = map[string]runtimeRecipe{
+	"node": {
+		defaultVersion: "22",
+		setup: func(v string) []string {
+			return []string{
+				"curl -o- -s https://raw.githubusercontent.com/nvm-sh/nvm/v0.40.1/install.sh | bash",
+				`export NVM_DIR="$HOME/.nvm"`,
+				`. "$NVM_DIR/nvm.sh"`,
+				"nvm install " + v,
+				"nvm use " + v,
+			}
+		},
+		versionCommand: "node --version",
+	},
+	"bun": {
+		defaultVersion: "latest",
+		setup: func(v string) []string {
+			lines := []string{"$APT update", "$APT install unzip git"}
+			if v == "latest" {
+				lines = append(lines, "curl -fsSL https://bun.sh/install | bash")
+			} else {
+				lines = append(lines, releaseLines("IB_BUN_VERSION", "oven-sh/bun", "bun-v", v)...)
+				lines = append(lines, `curl -fsSL https://bun.sh/install | bash -s "bun-v$IB_BUN_VERSION"`)
+			}
+			return append(lines, `export PATH="$HOME/.bun/bin:$PATH"`)
+		},
+		versionCommand: "bun --version",
+	},
+	"deno": {
+		defaultVersion: "latest",
+		setup: func(v string) []string {
+			lines := []string{"$APT update", "$APT install unzip git"}
+			if v == "latest" {
+				lines = append(lines, "curl -fsSL https://deno.land/install.sh | sh -s -- -y")
+			} else {
+				lines = append(lines, releaseLines("IB_DENO_VERSION", "denoland/deno", "v", v)...)
+				lines = append(lines, `curl -fsSL https://deno.land/install.sh | sh -s -- -y "v$IB_DENO_VERSION"`)
+			}
+			return append(lines, `export PATH="$HOME/.deno/bin:$PATH"`)
+		},
+		versionCommand: "deno --version | head -n 1",
+	},
+	"python": {
+		defaultVersion: "3",
+		setup: func(v string) []string {
+			if v == "3" {
+				return []string{"$APT update", "$APT install python3 python3-venv python3-pip"}
+			}
+			return []string{
+				"$APT update",
+				"$APT install software-properties-common",
+				"$SUDO add-apt-repository -y ppa:deadsnakes/ppa",
+				"$APT update",
+				"$APT install python" + v + " python" + v + "-venv",
+				// Make the requested version the one found as python3/python
+				`mkdir -p "$HOME/.ib/bin"`,
+				`ln -sf "$(command -v python` + v + `)" "$HOME/.ib/bin/python3"`,
+				`ln -sf "$(command -v python` + v + `)" "$HOME/.ib/bin/python"`,
+				`export PATH="$HOME/.ib/bin:$PATH"`,
+			}
+		},
+		versionCommand: "python3 --version",
+	},
+	"go": {
+		defaultVersion: "1.23.4",
+		setup: func(v string) []string {
+			// Releases since Go 1.21 are always published with a patch version
+			if strings.Count(v, ".") == 1 {
+				v += ".0"
+			}
+			return []string{
+				`ARCH="$(dpkg --print-architecture)"`,
+				"curl -fsSL -o /tmp/go.tar.gz https://go.dev/dl/go" + v + ".linux-$ARCH.tar.gz",
+				"$SUDO rm -rf /usr/local/go",
+				"$SUDO tar -C /usr/local -xzf /tmp/go.tar.gz",
+				`export PATH="/usr/local/go/bin:$HOME/go/bin:$PATH"`,
+			}
+		},
+		versionCommand: "go version",
+	},
+}
+
+// releaseLines returns the shell lines setting variable to the release v of
+// the GitHub repository repo, whose tags are prefix followed by the version.
+// The installers only accept exact releases, so a partial version such as 1.1
+// or 2 is resolved to the latest stable release it matches.
+func releaseLines(variable, repo, prefix, v string) []string {
+	if strings.Count(v, ".") >= 2 {
+		return []string{variable + "=" + v}
+	}
+	return []string{
+		fmt.Sprintf(`%s="$(git ls-remote --tags --refs https://github.com/%s '%s%s.*' | sed 's#.*refs/tags/%s##' | grep -E '^[0-9]+\.[0-9]+\.[0-9]+$' | sort -V | tail -n 1)"`,
+			variable, repo, prefix, v, prefix),
+		fmt.Sprintf(`{ [ -n "$%s" ] || { echo "No release of %s matches %s"; false; }; }`, variable, repo, v),
+		fmt.Sprintf(`echo "Resolved %s to $%s"`, v, variable),
+	}
+}
+
+// runtimeVersionChars restricts versions to plain version numbers and names
+// like "latest", as they are interpolated into the run script.
+var runtimeVersionChars = regexp.MustCompile(`^[0-9A-Za-z.\-]+$`)
+
+// parseRuntimes validates a comma separated --runtime value such as
+// "node@24,go@1.23" and returns the normalized name@version specs.
+// "none" disables runtime provisioning entirely.
+func parseRuntimes(value string) ([]string, error) {
+	var specs []string
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(strings.ToLower(part))
+		if part == "" || part == "none" {
+			continue
+		}
+
+		name, ver, _ := strings.Cut(part, "@")
+		recipe, ok := runtimeRecipes[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported runtime %q (supported: %s, none)", name, strings.Join(runtimeNames(), ", "))
+		}
+		if ver == "" {
+			ver = recipe.defaultVersion
+		}
+		ver = strings.TrimPrefix(ver, "v")
+		if !runtimeVersionChars.MatchString(ver) {
+			return nil, fmt.Errorf("invalid version %q for runtime %s", ver, name)
+		}
+		specs = append(specs, name+"@"+ver)
+	}
+	return specs, nil
+}
+
+// runtimeSetupLines returns the shell lines needed to install every runtime
+// in specs, as returned by parseRuntimes.
+func runtimeSetupLines(specs []string) []string {
+	var lines []string
+	for _, spec := range specs {
+		name, ver, _ := strings.Cut(spec, "@")
+		lines = append(lines, "echo '--- installing "+spec+"'")
+		lines = append(lines, runtimeRecipes[name].setup(ver)...)
+	}
+	return lines
+}
+
+func runtimeNames() []string {
+	names := make([]string, 0, len(runtimeRecipes))
+	for name := range runtimeRecipes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
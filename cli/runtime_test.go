@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRuntimes(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"node", []string{"node@22"}},
+		{"Node@v24, bun@1.1", []string{"node@24", "bun@1.1"}},
+		{"go@1.23.4,python@3.12-rc", []string{"go@1.23.4", "python@3.12-rc"}},
+		{"none", nil},
+	}
+	for _, tt := range tests {
+		got, err := parseRuntimes(tt.value)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRuntimes(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+
+	for _, value := range []string{
+		"ruby@3",
+		"node@22;reboot",
+		"node@$(reboot)",
+		"node@22>out",
+		"node@22<in",
+		"node@22\treboot",
+		"node@22\nreboot",
+		"node@22 && reboot",
+		"node@lts/*",
+	} {
+		if got, err := parseRuntimes(value); err == nil {
+			t.Errorf("parseRuntimes(%q) = %v, want an error", value, got)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runScriptName is the script generated into the staged folder and executed
+// on the remote machine, both for --host runs and Terraform provisioned ones.
+const runScriptName = "run_benchmark.sh"
+
+// setupScriptName is where --setup-script is staged next to the run script.
+const setupScriptName = ".ib-setup.sh"
+
+// prepareScriptName is the script generated by buildPrepareScript, and
+// preparedEnvFile the variables it saves for the run scripts that follow.
+const (
+	prepareScriptName = ".ib-prepare.sh"
+	preparedEnvFile   = ".ib-prepared-env.sh"
+)
+
+// runScriptOptions holds everything needed to render the run script.
+type runScriptOptions struct {
+	Command  string
+	Runs     int
+	Warmups  int
+	Runtimes []string
+
+	// Variants replaces Command when comparing commands on the same machine.
+	// Their runs are interleaved so drift affects all of them alike.
+	// VariantSetup runs once in the directory of every variant after Setup.
+	Variants     []scriptVariant
+	VariantSetup []string
+
+	// Staged files exporting --env variables and --secret values. The
+	// secrets file is removed as soon as it has been sourced.
+	EnvFile     string
+	SecretsFile string
+
+	// User hooks. Setup runs once before the warmups, teardown once after the
+	// last run, BeforeEach/AfterEach around every measured run.
+	Setup      []string
+	Teardown   []string
+	BeforeEach []string
+	AfterEach  []string
+
+	// Stabilize enables the noise reduction steps when set
+	Stabilize *stabilizeOptions
+
+	// Perf is the --perf mode: "stat", "record" or empty
+	Perf string
+
+	// Verbose keeps the full output of quiet sections, used with --debug
+	Verbose bool
+
+	// SpotWatch reports spot interruption notices, for --spot
+	SpotWatch bool
+
+	// Ready waits for the server of --server-command, for at most
+	// ReadyTimeout, before the warmups
+	Ready        *readinessProbe
+	ReadyTimeout time.Duration
+
+	// Prepared skips the runtime, perf and setup sections, already run by
+	// the script of buildPrepareScript, and sources what they exported
+	Prepared bool
+}
+
+// scriptVariant is a named command measured alongside others, run from Dir
+// when set.
+type scriptVariant struct {
+	Name    string
+	Command string
+	Dir     string
+}
+
+// command returns the shell command running the variant.
+func (v scriptVariant) command() string {
+	if v.Dir == "" {
+		return v.Command
+	}
+	return "cd " + shellQuote(v.Dir) + " && " + v.Command
+}
+
+// variants returns the commands to measure, a single unnamed one unless
+// Variants is set.
+func (opts runScriptOptions) variants() []scriptVariant {
+	if len(opts.Variants) > 0 {
+		return opts.Variants
+	}
+	return []scriptVariant{{Command: opts.Command}}
+}
+
+// buildRunScript renders the bash script that prepares the remote machine and
+// runs the benchmark. Preparation steps are wrapped in sections (see
+// parseSections) so their output and timing never end up between the
+// BENCHMARK_START/BENCHMARK_END markers.
+func buildRunScript(opts runScriptOptions) string {
+	var b strings.Builder
+	writeScriptHeader(&b, opts)
+
+	// Teardown runs from the EXIT trap, so that it cleans up after a failed
+	// section too
+	if len(opts.Teardown) > 0 {
+		b.WriteString("ib_teardown() {\n")
+		writeReportedSection(&b, "teardown", opts.Teardown, false)
+		b.WriteString("}\n")
+	}
+
+	if opts.Prepared {
+		fmt.Fprintf(&b, ". ./%s\n", preparedEnvFile)
+	} else {
+		writePreparation(&b, opts)
+	}
+	if len(opts.VariantSetup) > 0 {
+		for _, variant := range opts.variants() {
+			setup := scriptVariant{Command: strings.Join(opts.VariantSetup, " && "), Dir: variant.Dir}
+			writeSection(&b, strings.TrimSpace("setup "+variant.Name), []string{"( " + setup.command() + " )"}, false)
+		}
+	}
+
+	// System information reflects the stabilized machine
+	if opts.Stabilize != nil {
+		b.WriteString(strings.Join(stabilizeLines(*opts.Stabilize), "\n") + "\n")
+	}
+	b.WriteString(strings.Join(sysinfoLines(opts.Runtimes), "\n") + "\n")
+	if opts.Stabilize != nil {
+		b.WriteString(strings.Join(settleLines(), "\n") + "\n")
+	}
+	if opts.Ready != nil {
+		writeSection(&b, "ready", opts.Ready.waitLines(opts.ReadyTimeout), false)
+	}
+	for i := 1; i <= opts.Warmups; i++ {
+		for _, variant := range opts.variants() {
+			writeSection(&b, strings.TrimSpace(fmt.Sprintf("warmup %d %s", i, variant.Name)), []string{variant.command()}, !opts.Verbose)
+		}
+	}
+
+	// GNU time provides the resource usage of every measured run
+	b.WriteString(`if [ ! -x /usr/bin/time ]; then { $APT install time || { $APT update && $APT install time; }; } > /dev/null 2>&1; fi` + "\n")
+	b.WriteString(`ib_measure() { rm -f .ib-rusage; if [ -x /usr/bin/time ]; then /usr/bin/time -o .ib-rusage -f '%U %S %M %w %c %I %O' "$@"; else "$@"; fi; }` + "\n")
+
+	b.WriteString("echo 'BENCHMARK_START'\n")
+	for i := 1; i <= opts.Runs; i++ {
+		for _, variant := range opts.variants() {
+			if len(opts.BeforeEach) > 0 {
+				writeSection(&b, "before-each "+runLabel(i, variant.Name), opts.BeforeEach, false)
+			}
+			if opts.Stabilize != nil {
+				b.WriteString(dropCachesLine + "\n")
+			}
+			fmt.Fprintf(&b, "echo 'Run %s'\n", runLabel(i, variant.Name))
+			writeMeasuredRun(&b, runKey(i, variant.Name), perfPrefix(opts.Perf), variant.command())
+			for _, line := range perfAfterRunLines(opts.Perf, runKey(i, variant.Name)) {
+				b.WriteString(line + "\n")
+			}
+			if len(opts.AfterEach) > 0 {
+				writeSection(&b, "after-each "+runLabel(i, variant.Name), opts.AfterEach, false)
+			}
+		}
+	}
+	b.WriteString("echo 'BENCHMARK_END'\n")
+
+	if opts.Perf == "record" {
+		writeSection(&b, "flamegraph", flamegraphLines(), !opts.Verbose)
+	}
+	return b.String()
+}
+
+// buildPrepareScript renders the script installing the runtimes and perf and
+// running the setup hooks ahead of several run scripts built with Prepared,
+// e.g. the steps of a bisect. The variables these sections export are saved
+// to preparedEnvFile, leaving out the unchanged ones such as secrets.
+func buildPrepareScript(opts runScriptOptions) string {
+	var b strings.Builder
+	writeScriptHeader(&b, opts)
+	b.WriteString("ib_env_before=$(export -p)\n")
+	writePreparation(&b, opts)
+	fmt.Fprintf(&b, "{ export -p | grep -vxF -f <(printf '%%s\\n' \"$ib_env_before\") || true; } > %s\n", preparedEnvFile)
+	return b.String()
+}
+
+// writePreparation emits the sections installing the runtimes and perf, then
+// the setup hooks.
+func writePreparation(b *strings.Builder, opts runScriptOptions) {
+	if len(opts.Runtimes) > 0 {
+		writeSection(b, "runtime "+strings.Join(opts.Runtimes, ","), runtimeSetupLines(opts.Runtimes), !opts.Verbose)
+	}
+	if opts.Perf != "" {
+		writeSection(b, "perf", perfSetupLines(), !opts.Verbose)
+	}
+	if len(opts.Setup) > 0 {
+		writeSection(b, "setup", opts.Setup, false)
+	}
+}
+
+// writeScriptHeader starts a script run on the remote machine: the helpers
+// shared by its sections and the variables of --env and --secret.
+func writeScriptHeader(b *strings.Builder, opts runScriptOptions) {
+	b.WriteString("#!/bin/bash\n")
+	b.WriteString(`cd "$(dirname "$0")"` + "\n")
+	b.WriteString(`SUDO=""; if [ "$(id -u)" -ne 0 ]; then SUDO="sudo"; fi` + "\n")
+	b.WriteString(`APT="$SUDO env DEBIAN_FRONTEND=noninteractive apt-get -o DPkg::Lock::Timeout=300 -y -qq"` + "\n")
+	b.WriteString(`ib_write() { echo "$1" | $SUDO tee "$2" > /dev/null 2>&1; }` + "\n")
+	// A single EXIT trap, as another one would replace it: the teardown hooks
+	// run first, then the settings changed by --stabilize are restored
+	b.WriteString(`ib_on_exit() { ib_exit_status=$?; if declare -F ib_teardown > /dev/null; then ib_teardown; fi; if declare -F ib_restore > /dev/null; then ib_restore; fi; exit $ib_exit_status; }` + "\n")
+	b.WriteString("trap ib_on_exit EXIT\n")
+	if opts.SpotWatch {
+		b.WriteString(strings.Join(spotWatchLines(), "\n") + "\n")
+	}
+	if opts.EnvFile != "" {
+		fmt.Fprintf(b, ". ./%s\n", opts.EnvFile)
+	}
+	if opts.SecretsFile != "" {
+		fmt.Fprintf(b, ". ./%s && rm -f %s\n", opts.SecretsFile, opts.SecretsFile)
+	}
+	// Written once the machines are provisioned, see runTopology
+	if opts.Ready != nil {
+		fmt.Fprintf(b, ". ./%s\n", serverEnvFile)
+	}
+}
+
+// writeMeasuredRun runs command in a child bash measured by ib_measure,
+// optionally through prefix (e.g. perf), and prints "IB_RUN_END <run key>
+// <exit> <wall µs> [resource usage]", parsed by parseRuns.
+func writeMeasuredRun(b *strings.Builder, key, prefix, command string) {
+	if prefix != "" {
+		prefix += " "
+	}
+	b.WriteString("ib_run_started=$(date +%s%N)\n")
+	b.WriteString("ib_measure " + prefix + "bash -c " + shellQuote(command) + "\n")
+	b.WriteString("ib_exit=$?\n")
+	b.WriteString("ib_wall=$(( ($(date +%s%N) - ib_run_started) / 1000 ))\n")
+	fmt.Fprintf(b, "echo \"IB_RUN_END %s $ib_exit $ib_wall $(tail -n 1 .ib-rusage 2>/dev/null)\"\n", key)
+}
+
+// writeSection emits lines as a single && chain run in the current shell, so
+// exported variables survive for the benchmark command. Quiet sections send
+// their output to a log file and only print its tail when they fail. A failed
+// section aborts the script.
+func writeSection(b *strings.Builder, name string, lines []string, quiet bool) {
+	writeReportedSection(b, name, lines, quiet)
+	b.WriteString("if [ $ib_status -ne 0 ]; then exit $ib_status; fi\n")
+}
+
+// writeReportedSection emits a section like writeSection, leaving its status
+// in $ib_status instead of aborting the script when it fails.
+func writeReportedSection(b *strings.Builder, name string, lines []string, quiet bool) {
+	logFile := ".ib-" + strings.NewReplacer(" ", "-", ",", "-", "@", "-", "[", "", "]", "").Replace(name) + ".log"
+
+	fmt.Fprintf(b, "echo 'IB_SECTION_START %s'\n", name)
+	b.WriteString("ib_started=$(date +%s%N)\n")
+	b.WriteString("{\n" + strings.Join(lines, " &&\n") + "\n}")
+	if quiet {
+		fmt.Fprintf(b, " > %s 2>&1", logFile)
+	}
+	b.WriteString("\nib_status=$?\n")
+	if quiet {
+		fmt.Fprintf(b, "if [ $ib_status -ne 0 ]; then tail -n 30 %s; fi\n", logFile)
+	}
+	fmt.Fprintf(b, "echo \"IB_SECTION_END %s $ib_status $(( ($(date +%%s%%N) - ib_started) / 1000000 ))\"\n", name)
+}
+
+// scriptSection is a preparation step reported by the run script.
+type scriptSection struct {
+	Name     string
+	Status   int
+	Duration time.Duration
+	Output   string
+}
+
+// remoteExecPrefix matches the "aws_instance.example (remote-exec): " prefix
+// Terraform adds in front of every line printed by a provisioner.
+var remoteExecPrefix = regexp.MustCompile(`^\S+ \(remote-exec\): ?`)
+
+func stripRemoteExecPrefix(line []byte) []byte {
+	return remoteExecPrefix.ReplaceAll(line, nil)
+}
+
+// parseSections extracts the sections emitted by writeSection from the raw
+// remote output.
+func parseSections(output []byte) []scriptSection {
+	var sections []scriptSection
+	var current *scriptSection
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		line = stripRemoteExecPrefix(line)
+		text := strings.TrimRight(string(line), "\r")
+
+		if name, ok := strings.CutPrefix(text, "IB_SECTION_START "); ok {
+			current = &scriptSection{Name: name}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(text, "IB_SECTION_END "); ok {
+			fields := strings.Fields(rest)
+			if len(fields) >= 2 {
+				current.Status, _ = strconv.Atoi(fields[len(fields)-2])
+				ms, _ := strconv.Atoi(fields[len(fields)-1])
+				current.Duration = time.Duration(ms) * time.Millisecond
+			}
+			sections = append(sections, *current)
+			current = nil
+			continue
+		}
+		current.Output += text + "\n"
+	}
+
+	// The script was interrupted inside a section
+	if current != nil {
+		current.Status = -1
+		sections = append(sections, *current)
+	}
+	return sections
+}
+
+// reportSections prints a summary line and the output of each section, kept
+// apart from the benchmark output, and returns false if any of them failed.
+func reportSections(sections []scriptSection) bool {
+	ok := true
+	for _, section := range sections {
+		if section.Status != 0 {
+			errorLog("%s failed (exit %d) after %s", section.Name, section.Status, section.Duration.Round(time.Millisecond))
+			if section.Output != "" {
+				fmt.Print(redact(section.Output))
+			}
+			ok = false
+			continue
+		}
+		successLog("%s finished in %s", section.Name, section.Duration.Round(time.Millisecond))
+		if section.Output != "" {
+			fmt.Print(redact(section.Output))
+		}
+	}
+	return ok
+}
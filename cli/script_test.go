@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPreparedRunScript(t *testing.T) {
+	opts := runScriptOptions{
+		Command:  "node bench.js",
+		Runs:     2,
+		Runtimes: []string{"node@22"},
+		Perf:     "stat",
+		Setup:    []string{"npm ci"},
+	}
+	preparation := []string{"IB_SECTION_START runtime node@22", "IB_SECTION_START perf", "IB_SECTION_START setup"}
+
+	prepare := buildPrepareScript(opts)
+	for _, section := range preparation {
+		if !strings.Contains(prepare, section) {
+			t.Errorf("prepare script misses %q", section)
+		}
+	}
+	if strings.Contains(prepare, "BENCHMARK_START") {
+		t.Errorf("prepare script runs the benchmark")
+	}
+	if !strings.Contains(prepare, "> "+preparedEnvFile) {
+		t.Errorf("prepare script doesn't save %s", preparedEnvFile)
+	}
+
+	opts.Prepared = true
+	run := buildRunScript(opts)
+	for _, section := range preparation {
+		if strings.Contains(run, section) {
+			t.Errorf("prepared run script repeats %q", section)
+		}
+	}
+	if !strings.Contains(run, ". ./"+preparedEnvFile+"\n") {
+		t.Errorf("prepared run script doesn't source %s", preparedEnvFile)
+	}
+	if !strings.Contains(run, "perf stat") || strings.Count(run, "echo 'Run ") != 2 {
+		t.Errorf("prepared run script doesn't measure the runs with perf")
+	}
+}
+
+func TestTeardownRunsAfterFailedSection(t *testing.T) {
+	dir := t.TempDir()
+	script := buildRunScript(runScriptOptions{
+		Command:  "true",
+		Runs:     1,
+		Setup:    []string{"echo setting up", "false"},
+		Teardown: []string{"echo cleaning up"},
+	})
+	if strings.Count(script, "trap ") != 1 {
+		t.Errorf("run script sets %d traps, want a single EXIT trap", strings.Count(script, "trap "))
+	}
+	path := filepath.Join(dir, runScriptName)
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	output, err := exec.Command("bash", path).CombinedOutput()
+	if err == nil {
+		t.Errorf("run script succeeded after a failed setup")
+	}
+	if strings.Contains(string(output), "BENCHMARK_START") {
+		t.Errorf("run script measured runs after a failed setup:\n%s", output)
+	}
+	sections := parseSections(output)
+	if len(sections) != 2 || sections[1].Name != "teardown" || sections[1].Status != 0 {
+		t.Fatalf("sections = %+v, want setup then a successful teardown", sections)
+	}
+	if !strings.Contains(sections[1].Output, "cleaning up") {
+		t.Errorf("teardown output = %q", sections[1].Output)
+	}
+}
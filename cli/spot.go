@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// spotNoticeMarker starts the line printed by the run script when AWS
+// announces the interruption of the spot instance.
+const spotNoticeMarker = "IB_SPOT_INTERRUPTION"
+
+// SpotInterruption is an interruption notice received while the benchmark ran
+// on a spot instance. The runs of an interrupted attempt are discarded.
+type SpotInterruption struct {
+	// Machine names the machine of a --matrix run
+	Machine string `json:"machine,omitempty"`
+	Attempt int    `json:"attempt"`
+	// Action and Time come from the instance-action metadata: the instance
+	// is stopped or terminated at Time
+	Action string    `json:"action"`
+	Time   time.Time `json:"time"`
+}
+
+// addSpotVars sets the Terraform variables of the aws module requesting a spot
+// instance, for at most maxPrice per hour, or the on-demand price when it's
+// empty.
+func addSpotVars(vars map[string]string, maxPrice string) {
+	vars["spot"] = "true"
+	if maxPrice != "" {
+		vars["spot_max_price"] = maxPrice
+	}
+}
+
+// spotWatchLines returns the shell lines polling the instance metadata for
+// an interruption notice in the background. On a notice, the whole script is
+// stopped: the instance only has two minutes left and its runs are discarded.
+// The watcher exits with the script.
+func spotWatchLines() []string {
+	return []string{
+		`ib_spot_watch() {`,
+		`  while kill -0 $$ 2>/dev/null; do`,
+		`    ib_token=$(curl -s -m 2 -X PUT http://169.254.169.254/latest/api/token -H 'X-aws-ec2-metadata-token-ttl-seconds: 300')`,
+		`    if ib_notice=$(curl -s -f -m 2 -H "X-aws-ec2-metadata-token: $ib_token" http://169.254.169.254/latest/meta-data/spot/instance-action); then`,
+		`      echo "` + spotNoticeMarker + ` $ib_notice"`,
+		`      kill -TERM 0`,
+		`      return`,
+		`    fi`,
+		`    sleep 2 > /dev/null 2>&1`,
+		`  done`,
+		`}`,
+		`ib_spot_watch &`,
+	}
+}
+
+// parseSpotInterruption looks for the interruption notice in the output of
+// the run script.
+func parseSpotInterruption(output []byte) (SpotInterruption, bool) {
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		text := strings.TrimSpace(string(stripRemoteExecPrefix(line)))
+		rest, ok := strings.CutPrefix(text, spotNoticeMarker)
+		if !ok {
+			continue
+		}
+		var notice struct {
+			Action string    `json:"action"`
+			Time   time.Time `json:"time"`
+		}
+		// A notice that can't be read still means the instance is going away
+		if err := json.Unmarshal([]byte(strings.TrimSpace(rest)), &notice); err != nil {
+			debugLog("Unreadable spot interruption notice %q: %v", rest, err)
+		}
+		return SpotInterruption{Action: valueOr(notice.Action, "terminate"), Time: notice.Time}, true
+	}
+	return SpotInterruption{}, false
+}
+
+// describeInterruption prints when an interrupted instance goes away.
+func describeInterruption(interruption SpotInterruption) string {
+	description := interruption.Action
+	if !interruption.Time.IsZero() {
+		description += " at " + interruption.Time.Local().Format("15:04:05")
+	}
+	if interruption.Machine != "" {
+		description = interruption.Machine + ": " + description
+	}
+	return description
+}
+
+// interruptionsLine lists the spot interruptions of result.
+func interruptionsLine(result *BenchmarkResult) string {
+	var descriptions []string
+	for _, interruption := range result.Interruptions {
+		descriptions = append(descriptions, fmt.Sprintf("attempt %d, %s", interruption.Attempt, describeInterruption(interruption)))
+	}
+	return strings.Join(descriptions, "; ")
+}
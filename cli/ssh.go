@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// liveMachine is the connection to a provisioned machine, recorded in
+// ~/.ib/machines while it exists so that ib-agent-cli ssh can reach it from
+// another terminal.
+type liveMachine struct {
+	Name    string `json:"name"`
+	RunID   string `json:"run_id"`
+	Host    string `json:"host"`
+	User    string `json:"user"`
+	KeyPath string `json:"key_path"`
+}
+
+// machinesDir returns ~/.ib/machines.
+func machinesDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".ib", "machines"), nil
+}
+
+// registerMachine records the connection to m, and to its client machine as
+// <name>-client, with the key of the run.
+func registerMachine(m *machine, key *sshKey) error {
+	dir, err := machinesDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	hosts := map[string]*remoteHost{m.name: m.remote}
+	if m.client != nil {
+		hosts[m.name+"-client"] = m.client
+	}
+	for name, host := range hosts {
+		data, err := json.Marshal(liveMachine{Name: name, RunID: key.RunID, Host: host.Host, User: host.User, KeyPath: key.Path})
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+".json"), data, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unregisterMachine forgets the connection to m once it is being destroyed.
+func unregisterMachine(m *machine) {
+	dir, err := machinesDir()
+	if err != nil || m.name == "" {
+		return
+	}
+	os.Remove(filepath.Join(dir, m.name+".json"))
+	os.Remove(filepath.Join(dir, m.name+"-client.json"))
+}
+
+// findLiveMachines returns the machines named name, or provisioned for the
+// runs whose ID starts with it. The records of machines whose key is gone,
+// left behind by a run that was killed, are removed.
+func findLiveMachines(name string) ([]liveMachine, error) {
+	dir, err := machinesDir()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var found []liveMachine
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var live liveMachine
+		if err := json.Unmarshal(data, &live); err != nil {
+			debugLog("Skipping unreadable machine %s: %v", path, err)
+			continue
+		}
+		if !fileExists(live.KeyPath) {
+			debugLog("Forgetting %s, its SSH key is gone", live.Name)
+			os.Remove(path)
+			continue
+		}
+		if live.Name == name || strings.HasPrefix(live.RunID, name) {
+			found = append(found, live)
+		}
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Name < found[j].Name })
+	return found, nil
+}
+
+// shell opens an interactive shell on the remote machine and returns once
+// the user exits it.
+func (r *remoteHost) shell() error {
+	args := append(r.options(), "-t", r.target())
+	debugLog("Opening a shell on %s", r.Host)
+	cmd := exec.Command("ssh", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// sshCommand opens a shell on a machine of a run that is still going, e.g.
+// one paused by --pause-before-destroy.
+func sshCommand(arguments []string) {
+	flags := flag.NewFlagSet("ssh", flag.ExitOnError)
+	flags.Usage = printUsageAndExit
+	flags.Parse(arguments)
+	if flags.NArg() != 1 {
+		errorLog("Usage: ib-agent-cli ssh <run-id|name>")
+		os.Exit(1)
+	}
+
+	found, err := findLiveMachines(flags.Arg(0))
+	if err != nil {
+		errorLog("Failed to read the provisioned machines: %v", err)
+		os.Exit(1)
+	}
+	switch {
+	case len(found) == 0:
+		errorLog("No provisioned machine for %q: machines are destroyed at the end of their run, use --pause-before-destroy to keep them", flags.Arg(0))
+		os.Exit(1)
+	case len(found) > 1:
+		var names []string
+		for _, live := range found {
+			names = append(names, live.Name)
+		}
+		errorLog("%q matches several machines, pick one of: %s", flags.Arg(0), strings.Join(names, ", "))
+		os.Exit(1)
+	}
+
+	live := found[0]
+	infoLog("Connecting to %s (%s@%s)", live.Name, live.User, live.Host)
+	remote := &remoteHost{Host: live.Host, User: live.User, KeyPath: live.KeyPath, Ephemeral: true}
+	if err := remote.shell(); err != nil {
+		// The exit status of the last command of the shell is passed on
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		errorLog("Failed to connect to %s: %v", live.Name, err)
+		os.Exit(1)
+	}
+}
+
+// pauseBeforeDestroy opens a shell on the machine that ran the benchmark, for
+// --pause-before-destroy. It returns once the user exits it, for the machine
+// to be destroyed.
+func pauseBeforeDestroy(m *machine) {
+	infoLog("Paused before destroying %s: exit the shell to destroy it", m.name)
+	if m.client != nil {
+		infoLog("The client machine is reachable from another terminal with: ib-agent-cli ssh %s-client", m.name)
+	}
+	if err := m.remote.shell(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			errorLog("Failed to open a shell on %s: %v", m.name, err)
+		}
+	}
+}
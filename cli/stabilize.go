@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+// stabilizeOptions configures the --stabilize steps of the run script.
+type stabilizeOptions struct {
+	DisableASLR bool
+	// Restore puts the original settings back when the script exits, needed
+	// on --host machines that outlive the benchmark.
+	Restore bool
+}
+
+// StabilizeStep is the outcome of a noise reduction step, reported by the run
+// script with an IB_STABILIZE line.
+type StabilizeStep struct {
+	Name    string `json:"name"`
+	Applied bool   `json:"applied"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+const cpuSysfs = "/sys/devices/system/cpu"
+
+// stabilizeLines returns the shell lines tuning the machine for stable
+// measurements. Every step reports whether it could be applied, since most
+// of them depend on the hardware and on the hypervisor.
+func stabilizeLines(opts stabilizeOptions) []string {
+	lines := []string{
+		`ib_report() { echo "IB_STABILIZE $1 $2 $3"; }`,
+
+		// Frequency scaling governor
+		`IB_GOVERNOR=$(cat ` + cpuSysfs + `/cpu0/cpufreq/scaling_governor 2>/dev/null)`,
+		`if [ -n "$IB_GOVERNOR" ] && grep -qw performance ` + cpuSysfs + `/cpu0/cpufreq/scaling_available_governors 2>/dev/null; then`,
+		`  for f in ` + cpuSysfs + `/cpu*/cpufreq/scaling_governor; do ib_write performance "$f"; done`,
+		`  ib_report governor applied "performance (was $IB_GOVERNOR)"`,
+		`else`,
+		`  IB_GOVERNOR=""`,
+		`  ib_report governor unsupported "no cpufreq performance governor"`,
+		`fi`,
+
+		// Turbo/boost, exposed differently by intel_pstate and acpi-cpufreq
+		`IB_NO_TURBO=""; IB_BOOST=""`,
+		`if [ -f ` + cpuSysfs + `/intel_pstate/no_turbo ] && IB_NO_TURBO=$(cat ` + cpuSysfs + `/intel_pstate/no_turbo) && ib_write 1 ` + cpuSysfs + `/intel_pstate/no_turbo; then`,
+		`  ib_report turbo applied "intel_pstate turbo disabled"`,
+		`elif [ -f ` + cpuSysfs + `/cpufreq/boost ] && IB_BOOST=$(cat ` + cpuSysfs + `/cpufreq/boost) && ib_write 0 ` + cpuSysfs + `/cpufreq/boost; then`,
+		`  IB_NO_TURBO=""`,
+		`  ib_report turbo applied "cpufreq boost disabled"`,
+		`else`,
+		`  IB_NO_TURBO=""; IB_BOOST=""`,
+		`  ib_report turbo unsupported "no turbo/boost control"`,
+		`fi`,
+	}
+
+	lines = append(lines, `IB_ASLR=""`)
+	if opts.DisableASLR {
+		lines = append(lines,
+			`if IB_ASLR=$(cat /proc/sys/kernel/randomize_va_space) && ib_write 0 /proc/sys/kernel/randomize_va_space; then`,
+			`  ib_report aslr applied "disabled (was $IB_ASLR)"`,
+			`else`,
+			`  IB_ASLR=""`,
+			`  ib_report aslr unsupported "cannot write randomize_va_space"`,
+			`fi`,
+		)
+	}
+
+	lines = append(lines,
+		// Pin this shell, and so every command it starts, to the isolated
+		// cores. Without isolcpus, keep CPU 0 for the system.
+		`IB_CPUS=$(cat `+cpuSysfs+`/isolated 2>/dev/null)`,
+		`IB_PIN_DETAIL="isolated CPUs $IB_CPUS"`,
+		`if [ -z "$IB_CPUS" ] && [ "$(nproc)" -gt 1 ]; then IB_CPUS="1-$(( $(nproc) - 1 ))"; IB_PIN_DETAIL="CPUs $IB_CPUS (no isolated cores, CPU 0 left to the system)"; fi`,
+		`if [ -n "$IB_CPUS" ] && command -v taskset > /dev/null && taskset -acp "$IB_CPUS" $$ > /dev/null 2>&1; then`,
+		`  ib_report pinning applied "$IB_PIN_DETAIL"`,
+		`else`,
+		`  ib_report pinning unsupported "single core or taskset missing"`,
+		`fi`,
+
+		// Page caches are dropped before every run, see dropCachesLine
+		`IB_DROP_CACHES=""`,
+		`if sync && ib_write 3 /proc/sys/vm/drop_caches; then`,
+		`  IB_DROP_CACHES=1`,
+		`  ib_report page-cache applied "dropped before every run"`,
+		`else`,
+		`  ib_report page-cache unsupported "cannot write drop_caches"`,
+		`fi`,
+	)
+
+	// ib_restore is called by the EXIT trap of writeScriptHeader
+	if opts.Restore {
+		lines = append(lines,
+			`ib_restore() {`,
+			`  if [ -n "$IB_GOVERNOR" ]; then for f in `+cpuSysfs+`/cpu*/cpufreq/scaling_governor; do ib_write "$IB_GOVERNOR" "$f"; done; fi`,
+			`  if [ -n "$IB_NO_TURBO" ]; then ib_write "$IB_NO_TURBO" `+cpuSysfs+`/intel_pstate/no_turbo; fi`,
+			`  if [ -n "$IB_BOOST" ]; then ib_write "$IB_BOOST" `+cpuSysfs+`/cpufreq/boost; fi`,
+			`  if [ -n "$IB_ASLR" ]; then ib_write "$IB_ASLR" /proc/sys/kernel/randomize_va_space; fi`,
+			`  ib_report restore applied "original settings restored"`,
+			`}`,
+		)
+	}
+	return lines
+}
+
+// settleLines waits up to two minutes for the 1 minute load average to drop
+// below 10% of the cores, so setup work doesn't bleed into the measurements.
+func settleLines() []string {
+	return []string{
+		`ib_waited=0`,
+		`while [ $ib_waited -lt 120 ] && awk -v n="$(nproc)" '{ exit !($1 > 0.1 * n) }' /proc/loadavg; do sleep 5; ib_waited=$((ib_waited + 5)); done`,
+		`ib_report load applied "load average $(cut -d ' ' -f 1 /proc/loadavg) after waiting ${ib_waited}s"`,
+	}
+}
+
+// dropCachesLine is run before every measured run when --stabilize is set.
+const dropCachesLine = `if [ -n "$IB_DROP_CACHES" ]; then sync; ib_write 3 /proc/sys/vm/drop_caches; fi`
+
+// parseStabilizeSteps reads the IB_STABILIZE lines from the remote output.
+func parseStabilizeSteps(output []byte) []StabilizeStep {
+	var steps []StabilizeStep
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		text := strings.TrimSpace(string(stripRemoteExecPrefix(line)))
+		rest, ok := strings.CutPrefix(text, "IB_STABILIZE ")
+		if !ok {
+			continue
+		}
+		fields := strings.SplitN(rest, " ", 3)
+		if len(fields) < 2 {
+			continue
+		}
+		step := StabilizeStep{Name: fields[0], Applied: fields[1] == "applied"}
+		if len(fields) == 3 {
+			step.Detail = fields[2]
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// printStabilizeSteps reports which noise reduction steps took effect.
+func printStabilizeSteps(steps []StabilizeStep) {
+	for _, step := range steps {
+		if step.Applied {
+			successLog("Stabilize %s: %s", step.Name, step.Detail)
+		} else {
+			infoLog("Stabilize %s: unsupported (%s)", step.Name, step.Detail)
+		}
+	}
+}
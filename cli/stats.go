@@ -0,0 +1,217 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// significanceLevel is the p-value below which a difference is reported as
+// significant.
+const significanceLevel = 0.05
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total / float64(len(values))
+}
+
+// variance returns the sample variance of values.
+func variance(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	m := mean(values)
+	total := 0.0
+	for _, v := range values {
+		total += (v - m) * (v - m)
+	}
+	return total / float64(len(values)-1)
+}
+
+func stddev(values []float64) float64 {
+	return math.Sqrt(variance(values))
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// mannWhitneyU returns the two-sided p-value of the Mann-Whitney U test. Small
+// samples without ties use the exact distribution of U, the others the normal
+// approximation with tie and continuity corrections.
+func mannWhitneyU(a, b []float64) float64 {
+	m, n := len(a), len(b)
+	if m == 0 || n == 0 {
+		return 1
+	}
+
+	type sample struct {
+		value float64
+		first bool
+	}
+	samples := make([]sample, 0, m+n)
+	for _, v := range a {
+		samples = append(samples, sample{v, true})
+	}
+	for _, v := range b {
+		samples = append(samples, sample{v, false})
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i].value < samples[j].value })
+
+	// Tied values share the mean of their ranks
+	rankSum := 0.0
+	tieCorrection := 0.0
+	for i := 0; i < len(samples); {
+		j := i
+		for j < len(samples) && samples[j].value == samples[i].value {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if samples[k].first {
+				rankSum += rank
+			}
+		}
+		t := float64(j - i)
+		tieCorrection += t*t*t - t
+		i = j
+	}
+	u := rankSum - float64(m*(m+1))/2
+
+	if tieCorrection == 0 && m <= 20 && n <= 20 {
+		return exactMannWhitneyP(m, n, u)
+	}
+
+	total := float64(m + n)
+	mu := float64(m*n) / 2
+	sigma := math.Sqrt(float64(m*n) / 12 * ((total + 1) - tieCorrection/(total*(total-1))))
+	if sigma == 0 {
+		return 1
+	}
+	z := (math.Abs(u-mu) - 0.5) / sigma
+	if z < 0 {
+		z = 0
+	}
+	return math.Erfc(z / math.Sqrt2)
+}
+
+// exactMannWhitneyP computes the two-sided p-value of u from the number of
+// orderings of m and n samples giving each value of U.
+func exactMannWhitneyP(m, n int, u float64) float64 {
+	// counts[i][j][k] is the number of orderings of i and j samples with U = k
+	counts := make([][][]float64, m+1)
+	for i := range counts {
+		counts[i] = make([][]float64, n+1)
+		for j := range counts[i] {
+			counts[i][j] = make([]float64, i*j+1)
+			if i == 0 || j == 0 {
+				counts[i][j][0] = 1
+				continue
+			}
+			for k := range counts[i][j] {
+				// The largest sample is either from the first group, beating
+				// all j others, or from the second one
+				if k >= j && k-j < len(counts[i-1][j]) {
+					counts[i][j][k] += counts[i-1][j][k-j]
+				}
+				if k < len(counts[i][j-1]) {
+					counts[i][j][k] += counts[i][j-1][k]
+				}
+			}
+		}
+	}
+
+	total, lower, upper := 0.0, 0.0, 0.0
+	for k, count := range counts[m][n] {
+		total += count
+		if float64(k) <= u {
+			lower += count
+		}
+		if float64(k) >= u {
+			upper += count
+		}
+	}
+	return math.Min(1, 2*math.Min(lower, upper)/total)
+}
+
+// welchTTest returns the two-sided p-value of Welch's t-test.
+func welchTTest(a, b []float64) float64 {
+	if len(a) < 2 || len(b) < 2 {
+		return 1
+	}
+	va, vb := variance(a)/float64(len(a)), variance(b)/float64(len(b))
+	if va+vb == 0 {
+		if mean(a) == mean(b) {
+			return 1
+		}
+		return 0
+	}
+	t := (mean(a) - mean(b)) / math.Sqrt(va+vb)
+	df := (va + vb) * (va + vb) / (va*va/float64(len(a)-1) + vb*vb/float64(len(b)-1))
+	return regularizedIncompleteBeta(df/2, 0.5, df/(df+t*t))
+}
+
+// regularizedIncompleteBeta evaluates I_x(a, b) with the continued fraction
+// from Numerical Recipes.
+func regularizedIncompleteBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	lga, _ := math.Lgamma(a)
+	lgb, _ := math.Lgamma(b)
+	lgab, _ := math.Lgamma(a + b)
+	front := math.Exp(lgab - lga - lgb + a*math.Log(x) + b*math.Log(1-x))
+	if x < (a+1)/(a+b+2) {
+		return front * betaContinuedFraction(a, b, x) / a
+	}
+	return 1 - front*betaContinuedFraction(b, a, 1-x)/b
+}
+
+func betaContinuedFraction(a, b, x float64) float64 {
+	const tiny = 1e-300
+	c, d := 1.0, 1-(a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for m := 1; m <= 300; m++ {
+		fm := float64(m)
+		for _, num := range []float64{
+			fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm)),
+			-(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1)),
+		} {
+			d = 1 + num*d
+			if math.Abs(d) < tiny {
+				d = tiny
+			}
+			c = 1 + num/c
+			if math.Abs(c) < tiny {
+				c = tiny
+			}
+			d = 1 / d
+			h *= d * c
+		}
+		if math.Abs(d*c-1) < 1e-12 {
+			break
+		}
+	}
+	return h
+}
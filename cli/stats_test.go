@@ -0,0 +1,111 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// The reference p-values come from scipy.stats: mannwhitneyu with
+// method="exact" or "asymptotic" (use_continuity=True), and ttest_ind with
+// equal_var=False.
+
+func closeTo(got, want, tolerance float64) bool {
+	return math.Abs(got-want) <= tolerance*math.Max(1, math.Abs(want))
+}
+
+func TestMannWhitneyU(t *testing.T) {
+	var big, shifted []float64
+	for i := 0; i < 25; i++ {
+		big = append(big, 1+0.01*float64(i))
+	}
+	for i := 0; i < 22; i++ {
+		shifted = append(shifted, 1.1+0.012*float64(i))
+	}
+
+	tests := []struct {
+		name string
+		a, b []float64
+		want float64
+	}{
+		// Exact distribution: small samples without ties
+		{"exact separated", []float64{1, 2, 3}, []float64{4, 5, 6}, 0.1},
+		{"exact reversed", []float64{4, 5, 6}, []float64{1, 2, 3}, 0.1},
+		{"exact interleaved", []float64{1, 3, 5}, []float64{2, 4, 6}, 0.7},
+		{"exact five each", []float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}, 2.0 / 252},
+		{"exact unequal sizes", []float64{19, 22, 16, 29, 24}, []float64{20, 11, 17, 12}, 1.0 / 9},
+		{"exact single values", []float64{1}, []float64{2}, 1},
+		// Normal approximation: large samples, or ties
+		{"normal large samples", big, shifted, 0.00011383119863913876},
+		{"normal with ties", []float64{1, 2, 2, 3, 4}, []float64{2, 3, 5, 6, 6}, 0.11049202405566808},
+		{"all tied", []float64{1, 1}, []float64{1, 1}, 1},
+		{"empty", nil, []float64{1, 2}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mannWhitneyU(tt.a, tt.b); !closeTo(got, tt.want, 1e-9) {
+				t.Errorf("mannWhitneyU(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExactMannWhitneyP(t *testing.T) {
+	// scipy: mannwhitneyu([19, 22, 16, 29, 24], [20, 11, 17, 12], method="asymptotic")
+	// gives 0.11134688653314041 for U = 17, the exact test 1/9
+	if got := exactMannWhitneyP(5, 4, 17); !closeTo(got, 1.0/9, 1e-12) {
+		t.Errorf("exactMannWhitneyP(5, 4, 17) = %v, want %v", got, 1.0/9)
+	}
+	// The distribution of U is symmetric
+	if got, want := exactMannWhitneyP(5, 4, 3), exactMannWhitneyP(5, 4, 17); got != want {
+		t.Errorf("exactMannWhitneyP(5, 4, 3) = %v, want %v", got, want)
+	}
+	if got := exactMannWhitneyP(4, 4, 8); got != 1 {
+		t.Errorf("exactMannWhitneyP(4, 4, 8) = %v, want 1", got)
+	}
+}
+
+func TestWelchTTest(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float64
+		want float64
+	}{
+		{"different spreads", []float64{1, 2, 3, 4, 5}, []float64{2, 4, 6, 8, 10}, 0.10753119493072048},
+		{"clear difference", []float64{10.1, 10.3, 9.8, 10.0, 10.2}, []float64{10.9, 11.2, 10.8, 11.1, 11.0, 10.7}, 4.590473793580732e-05},
+		{"no difference", []float64{1.0, 1.2, 0.9}, []float64{1.05, 1.1, 0.95, 1.0}, 0.9358089732872844},
+		{"single value", []float64{1}, []float64{2, 3}, 1},
+		{"zero variance, same mean", []float64{1, 1, 1}, []float64{1, 1, 1}, 1},
+		{"zero variance, different means", []float64{1, 1, 1}, []float64{2, 2, 2}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := welchTTest(tt.a, tt.b); !closeTo(got, tt.want, 1e-6) {
+				t.Errorf("welchTTest(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegularizedIncompleteBeta(t *testing.T) {
+	// I_x(2, 3) = sum over j from 2 to 4 of C(4, j) x^j (1-x)^(4-j)
+	beta23 := func(x float64) float64 {
+		return 6*x*x*(1-x)*(1-x) + 4*x*x*x*(1-x) + x*x*x*x
+	}
+	tests := []struct {
+		a, b, x float64
+		want    float64
+	}{
+		{1, 1, 0.3, 0.3},
+		{2.5, 1, 0.4, math.Pow(0.4, 2.5)},
+		{7, 7, 0.5, 0.5},
+		{2, 3, 0.2, beta23(0.2)},
+		{2, 3, 0.8, beta23(0.8)},
+		{3, 2, 0, 0},
+		{3, 2, 1, 1},
+	}
+	for _, tt := range tests {
+		if got := regularizedIncompleteBeta(tt.a, tt.b, tt.x); !closeTo(got, tt.want, 1e-10) {
+			t.Errorf("regularizedIncompleteBeta(%v, %v, %v) = %v, want %v", tt.a, tt.b, tt.x, got, tt.want)
+		}
+	}
+}
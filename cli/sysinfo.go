@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SystemInfo describes the machine a benchmark ran on.
+type SystemInfo struct {
+	Provider       string            `json:"provider"`
+	InstanceType   string            `json:"instance_type,omitempty"`
+	Location       string            `json:"location,omitempty"`
+	CPUModel       string            `json:"cpu_model"`
+	Cores          int               `json:"cores"`
+	CPUMHz         string            `json:"cpu_mhz,omitempty"`
+	Governor       string            `json:"governor,omitempty"`
+	MemoryKB       int64             `json:"memory_kb"`
+	Kernel         string            `json:"kernel"`
+	OS             string            `json:"os"`
+	Arch           string            `json:"arch"`
+	Virtualization string            `json:"virtualization,omitempty"`
+	Runtimes       map[string]string `json:"runtimes,omitempty"`
+}
+
+// sysinfoLines returns the shell lines printing key=value system information
+// between IB_SYSINFO_START and IB_SYSINFO_END, parsed by parseSystemInfo.
+func sysinfoLines(runtimes []string) []string {
+	lines := []string{
+		"echo 'IB_SYSINFO_START'",
+		`echo "cpu_model=$(lscpu 2>/dev/null | sed -n 's/^Model name: *//p' | head -n 1)"`,
+		`echo "cores=$(nproc)"`,
+		`echo "cpu_mhz=$(lscpu 2>/dev/null | sed -n 's/^CPU max MHz: *//p' | head -n 1)"`,
+		`echo "cpu_mhz_current=$(awk -F': ' '/^cpu MHz/ {print $2; exit}' /proc/cpuinfo)"`,
+		`echo "governor=$(cat /sys/devices/system/cpu/cpu0/cpufreq/scaling_governor 2>/dev/null)"`,
+		`echo "memory_kb=$(awk '/^MemTotal/ {print $2}' /proc/meminfo)"`,
+		`echo "kernel=$(uname -r)"`,
+		`echo "os=$(. /etc/os-release 2>/dev/null && echo "$PRETTY_NAME")"`,
+		`echo "arch=$(uname -m)"`,
+		`echo "virtualization=$(systemd-detect-virt 2>/dev/null)"`,
+	}
+	for _, spec := range runtimes {
+		name, _, _ := strings.Cut(spec, "@")
+		lines = append(lines, fmt.Sprintf(`echo "runtime.%s=$(%s 2>&1 | head -n 1)"`, name, runtimeRecipes[name].versionCommand))
+	}
+	return append(lines, "echo 'IB_SYSINFO_END'")
+}
+
+// parseSystemInfo reads the block printed by sysinfoLines.
+func parseSystemInfo(output []byte) SystemInfo {
+	var info SystemInfo
+	var currentMHz string
+	inBlock := false
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		text := strings.TrimSpace(string(stripRemoteExecPrefix(line)))
+		switch {
+		case text == "IB_SYSINFO_START":
+			inBlock = true
+			continue
+		case text == "IB_SYSINFO_END":
+			inBlock = false
+			continue
+		case !inBlock:
+			continue
+		}
+
+		key, value, ok := strings.Cut(text, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "cpu_model":
+			info.CPUModel = value
+		case "cores":
+			info.Cores, _ = strconv.Atoi(value)
+		case "cpu_mhz":
+			info.CPUMHz = value
+		case "cpu_mhz_current":
+			currentMHz = value
+		case "governor":
+			info.Governor = value
+		case "memory_kb":
+			info.MemoryKB, _ = strconv.ParseInt(value, 10, 64)
+		case "kernel":
+			info.Kernel = value
+		case "os":
+			info.OS = value
+		case "arch":
+			info.Arch = value
+		case "virtualization":
+			info.Virtualization = value
+		default:
+			if name, ok := strings.CutPrefix(key, "runtime."); ok {
+				if info.Runtimes == nil {
+					info.Runtimes = make(map[string]string)
+				}
+				info.Runtimes[name] = value
+			}
+		}
+	}
+
+	// Virtual machines often don't expose the max frequency
+	if info.CPUMHz == "" {
+		info.CPUMHz = currentMHz
+	}
+	return info
+}
+
+// printSystemInfo prints the header shown before the benchmark output.
+func printSystemInfo(info SystemInfo) {
+	for _, line := range systemInfoLines(info) {
+		infoLog("%-10s%s", line[0]+":", line[1])
+	}
+}
+
+// systemInfoLines describes info as label and value pairs, shared by the
+// terminal output and the reports.
+func systemInfoLines(info SystemInfo) [][2]string {
+	machine := info.Provider
+	if info.InstanceType != "" {
+		machine += " " + info.InstanceType
+	}
+	if info.Location != "" {
+		machine += " in " + info.Location
+	}
+
+	cpu := fmt.Sprintf("%s, %d cores", valueOr(info.CPUModel, "unknown CPU"), info.Cores)
+	if info.CPUMHz != "" {
+		if mhz, err := strconv.ParseFloat(info.CPUMHz, 64); err == nil {
+			cpu += fmt.Sprintf(" @ %.0f MHz", mhz)
+		}
+	}
+	if info.Governor != "" {
+		cpu += ", governor: " + info.Governor
+	}
+
+	system := fmt.Sprintf("%s (kernel %s, %s", valueOr(info.OS, "unknown OS"), info.Kernel, info.Arch)
+	if info.Virtualization != "" {
+		system += ", " + info.Virtualization
+	}
+
+	lines := [][2]string{
+		{"Machine", machine},
+		{"CPU", cpu},
+		{"Memory", fmt.Sprintf("%.1f GiB", float64(info.MemoryKB)/(1024*1024))},
+		{"System", system + ")"},
+	}
+
+	if len(info.Runtimes) > 0 {
+		names := make([]string, 0, len(info.Runtimes))
+		for name := range info.Runtimes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var versions []string
+		for _, name := range names {
+			versions = append(versions, name+" "+info.Runtimes[name])
+		}
+		lines = append(lines, [2]string{"Runtimes", strings.Join(versions, ", ")})
+	}
+	return lines
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSystemInfo(t *testing.T) {
+	output := []byte(`cores=99
+IB_SYSINFO_START
+cpu_model=AMD EPYC 7R13 Processor
+aws_instance.example (remote-exec): cores=2
+cpu_mhz=
+cpu_mhz_current=2649.998
+governor=
+memory_kb=3944376
+kernel=6.5.0-1014-aws
+os=Ubuntu 22.04.4 LTS
+arch=x86_64
+virtualization=amazon
+runtime.node=v20.11.1
+runtime.go=go version go1.22.1 linux/amd64
+IB_SYSINFO_END
+arch=aarch64
+`)
+	want := SystemInfo{
+		CPUModel:       "AMD EPYC 7R13 Processor",
+		Cores:          2,
+		CPUMHz:         "2649.998",
+		MemoryKB:       3944376,
+		Kernel:         "6.5.0-1014-aws",
+		OS:             "Ubuntu 22.04.4 LTS",
+		Arch:           "x86_64",
+		Virtualization: "amazon",
+		Runtimes: map[string]string{
+			"node": "v20.11.1",
+			"go":   "go version go1.22.1 linux/amd64",
+		},
+	}
+	if got := parseSystemInfo(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseSystemInfo() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestParseSystemInfoPrefersMaxFrequency(t *testing.T) {
+	output := []byte("IB_SYSINFO_START\ncpu_mhz=3500.0000\ncpu_mhz_current=1200.000\nIB_SYSINFO_END\n")
+	if got := parseSystemInfo(output).CPUMHz; got != "3500.0000" {
+		t.Errorf("CPUMHz = %q, want the max frequency", got)
+	}
+}
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/hc-install/product"
+	"github.com/hashicorp/hc-install/releases"
+	"github.com/hashicorp/terraform-exec/tfexec"
+)
+
+// machine is a benchmark machine provisioned with Terraform.
+type machine struct {
+	// name is the name variable of the module, e.g. ib-<run-id>
+	name         string
+	terraform    *tfexec.Terraform
+	dir          string
+	vars         []string
+	remote       *remoteHost
+	instanceType string
+	location     string
+	// client is the load generator provisioned next to the machine for
+	// --server-command, which reaches it on privateIP
+	client    *remoteHost
+	privateIP string
+}
+
+// terraformVersion is the version of Terraform downloaded when no binary is
+// given with --terraform-bin.
+var terraformVersion = version.Must(version.NewVersion("1.7.5"))
+
+// terraformBin is the Terraform binary of --terraform-bin or $IB_TERRAFORM,
+// empty to use the cached download.
+var terraformBin string
+
+// terraformExec is the Terraform binary found by findTerraform, shared by the
+// machines of a matrix.
+var terraformExec struct {
+	sync.Mutex
+	path string
+}
+
+// terraformInit serializes terraform init, as the machines of a matrix share
+// the plugin cache of TF_PLUGIN_CACHE_DIR, which isn't safe for concurrent
+// writers.
+var terraformInit sync.Mutex
+
+// findTerraform returns the Terraform binary to run, downloading it into the
+// user cache directory the first time.
+func findTerraform() (string, error) {
+	terraformExec.Lock()
+	defer terraformExec.Unlock()
+	if terraformExec.path != "" {
+		return terraformExec.path, nil
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	cacheDir = filepath.Join(cacheDir, "ib-agent-cli", "terraform")
+	// Every machine gets a new module directory, the providers are only
+	// downloaded once
+	if os.Getenv("TF_PLUGIN_CACHE_DIR") == "" {
+		pluginDir := filepath.Join(cacheDir, "plugins")
+		if err := os.MkdirAll(pluginDir, 0755); err != nil {
+			return "", err
+		}
+		os.Setenv("TF_PLUGIN_CACHE_DIR", pluginDir)
+	}
+
+	var path string
+	if terraformBin != "" {
+		if path, err = exec.LookPath(terraformBin); err != nil {
+			return "", fmt.Errorf("invalid Terraform binary: %v", err)
+		}
+	} else if path, err = cachedTerraform(filepath.Join(cacheDir, terraformVersion.String())); err != nil {
+		return "", err
+	}
+	debugLog("Using Terraform %s", path)
+	terraformExec.path = path
+	return path, nil
+}
+
+// cachedTerraform returns the Terraform binary cached in dir, downloading it
+// when it is missing or doesn't match its checksum. Downloads are verified by
+// hc-install against the checksums signed by HashiCorp, the cached binary
+// against the checksum recorded after its download.
+func cachedTerraform(dir string) (string, error) {
+	path := filepath.Join(dir, product.Terraform.BinaryName())
+	checksumPath := path + ".sha256"
+	if recorded, err := os.ReadFile(checksumPath); err == nil {
+		checksum, err := fileChecksum(path)
+		if err == nil && checksum == strings.TrimSpace(string(recorded)) {
+			return path, nil
+		}
+		infoLog("The cached Terraform binary %s doesn't match its checksum, downloading it again", path)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	// Concurrent runs download into their own directory before moving the
+	// binary into place
+	downloadDir, err := os.MkdirTemp(dir, "download-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(downloadDir)
+	installer := &releases.ExactVersion{
+		Product:    product.Terraform,
+		Version:    terraformVersion,
+		InstallDir: downloadDir,
+	}
+	startSpinner(fmt.Sprintf("Downloading Terraform %s...", terraformVersion))
+	downloaded, err := installer.Install(context.Background())
+	stopSpinner()
+	if err != nil {
+		return "", fmt.Errorf("failed to install Terraform: %s", err)
+	}
+	defer installer.Remove(context.Background())
+
+	checksum, err := fileChecksum(downloaded)
+	if err != nil {
+		return "", err
+	}
+	if err := os.Rename(downloaded, path); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(checksumPath, []byte(checksum+"\n"), 0644); err != nil {
+		return "", err
+	}
+	successLog("Terraform %s cached in %s", terraformVersion, dir)
+	return path, nil
+}
+
+// fileChecksum returns the hex-encoded SHA-256 of the file at path.
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// initTerraform initializes the module in dir, finding Terraform first.
+func initTerraform(dir string) (*tfexec.Terraform, error) {
+	execPath, err := findTerraform()
+	if err != nil {
+		return nil, err
+	}
+	startSpinner("Initializing Terraform...")
+
+	// Initialize a new tfexec.Terraform object
+	terraform, err := tfexec.NewTerraform(dir, execPath)
+	if err != nil {
+		stopSpinner()
+		return nil, fmt.Errorf("failed to initialize Terraform: %s", err)
+	}
+	stopSpinner()
+
+	terraformInit.Lock()
+	defer terraformInit.Unlock()
+	debugLog("Initializing Terraform in %s", dir)
+	startSpinner("Initializing Terraform providers...")
+	// Without -upgrade, the providers are installed at the versions of the
+	// embedded lock file
+	err = terraform.Init(context.Background(),
+		tfexec.ForceCopy(true),
+	)
+	stopSpinner()
+	if err != nil {
+		errorLog("Failed to initialize Terraform: %s", err)
+		infoLog("Attempting to initialize with alternative options...")
+
+		// Try again with minimal options
+		startSpinner("Reinitializing Terraform...")
+		err = terraform.Init(context.Background())
+		stopSpinner()
+		if err != nil {
+			fmt.Println("\nTo fix this manually, try running: cd", dir, "&&", execPath, "init")
+			return nil, fmt.Errorf("failed to initialize Terraform: %s", err)
+		}
+	}
+	successLog("Terraform initialized successfully")
+	return terraform, nil
+}
+
+// provisionMachine applies the Terraform module in dir with vars (KEY=VALUE)
+// and the public key of key, and returns the machine once it accepts SSH
+// connections.
+func provisionMachine(dir string, vars []string, key *sshKey) (*machine, error) {
+	vars = append(append([]string(nil), vars...), key.terraformVar())
+	terraform, err := initTerraform(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := &bytes.Buffer{}
+	terraform.SetStdout(buffer)
+	terraform.SetStderr(buffer)
+
+	var applyVars []tfexec.ApplyOption
+	for _, v := range vars {
+		applyVars = append(applyVars, tfexec.Var(v))
+	}
+
+	startSpinner("Provisioning machine...")
+	// Run 'terraform apply' in the given directory
+	err = terraform.Apply(context.Background(), applyVars...)
+	stopSpinner()
+	if err != nil {
+		debugLog("Terraform apply output:\n%s", buffer.String())
+		return nil, fmt.Errorf("error running terraform apply: %s.\n"+
+			"⚠️  Although, an error occurred while running terraform apply, resources might have been created! Ensure to run:\n"+
+			"%s", err, destroyCommand(dir, terraform.ExecPath(), vars))
+	}
+	debugLog("Terraform apply completed successfully")
+
+	m := &machine{terraform: terraform, dir: dir, vars: vars}
+	for _, v := range vars {
+		if name, ok := strings.CutPrefix(v, "name="); ok {
+			m.name = name
+		}
+	}
+	outputs, err := stringOutputs(terraform, "host", "ssh_user", "instance_type", "location", "client_host", "server_private_ip")
+	if err != nil {
+		return m, fmt.Errorf("failed to read connection details: %v", err)
+	}
+	m.instanceType = outputs["instance_type"]
+	m.location = outputs["location"]
+	m.remote = &remoteHost{Host: outputs["host"], User: outputs["ssh_user"], KeyPath: key.Path, Ephemeral: true}
+	hosts := []*remoteHost{m.remote}
+	if outputs["client_host"] != "" {
+		client := *m.remote
+		client.Host = outputs["client_host"]
+		m.client = &client
+		m.privateIP = outputs["server_private_ip"]
+		hosts = append(hosts, m.client)
+	}
+
+	startSpinner("Waiting for the machine to accept SSH connections...")
+	for _, host := range hosts {
+		if err = host.waitReady(machineReadyTimeout); err != nil {
+			break
+		}
+	}
+	stopSpinner()
+	if err != nil {
+		return m, err
+	}
+	// ib-agent-cli ssh connects to the machine until it is destroyed
+	if err := registerMachine(m, key); err != nil {
+		errorLog("Failed to record the machine for ib-agent-cli ssh: %v", err)
+	}
+	if m.client != nil {
+		successLog("Machines provisioned successfully (server %s, client %s)", m.remote.Host, m.client.Host)
+		return m, nil
+	}
+	successLog("Machine provisioned successfully (%s)", m.remote.Host)
+	return m, nil
+}
+
+// planMachine runs terraform plan for the module in dir with vars and returns
+// the resources it would change, e.g. "aws_instance.example (create)",
+// without creating anything.
+func planMachine(dir string, vars []string, key *sshKey) ([]string, error) {
+	vars = append(append([]string(nil), vars...), key.terraformVar())
+	terraform, err := initTerraform(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := &bytes.Buffer{}
+	terraform.SetStdout(buffer)
+	terraform.SetStderr(buffer)
+
+	planFile := filepath.Join(dir, "ib.tfplan")
+	planVars := []tfexec.PlanOption{tfexec.Out(planFile)}
+	for _, v := range vars {
+		planVars = append(planVars, tfexec.Var(v))
+	}
+
+	startSpinner("Planning machine...")
+	_, err = terraform.Plan(context.Background(), planVars...)
+	stopSpinner()
+	if err != nil {
+		debugLog("Terraform plan output:\n%s", buffer.String())
+		return nil, fmt.Errorf("error running terraform plan: %s", err)
+	}
+	plan, err := terraform.ShowPlanFile(context.Background(), planFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the plan: %s", err)
+	}
+
+	var resources []string
+	for _, change := range plan.ResourceChanges {
+		if change.Change == nil || change.Change.Actions.NoOp() || change.Change.Actions.Read() {
+			continue
+		}
+		var actions []string
+		for _, action := range change.Change.Actions {
+			actions = append(actions, string(action))
+		}
+		resources = append(resources, fmt.Sprintf("%s (%s)", change.Address, strings.Join(actions, ", ")))
+	}
+	return resources, nil
+}
+
+// stringOutputs reads the given string outputs of the applied module.
+func stringOutputs(terraform *tfexec.Terraform, names ...string) (map[string]string, error) {
+	outputs, err := terraform.Output(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for _, name := range names {
+		output, ok := outputs[name]
+		if !ok {
+			return nil, fmt.Errorf("missing Terraform output %q", name)
+		}
+		var value string
+		if err := json.Unmarshal(output.Value, &value); err != nil {
+			return nil, fmt.Errorf("invalid Terraform output %q: %v", name, err)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// destroyCommand is the command to destroy the resources of the module in dir
+// by hand, with the variables they were created with: without them, Terraform
+// prompts for the required ones.
+func destroyCommand(dir, execPath string, vars []string) string {
+	command := "cd " + shellQuote(dir) + " && " + shellQuote(execPath) + " destroy"
+	for _, v := range vars {
+		command += " -var " + shellQuote(v)
+	}
+	return command
+}
+
+// destroy runs terraform destroy with the same variables used to provision
+// the machine, then removes its module directory. The SSH key of the run is
+// removed separately, it can be shared by several machines.
+func (m *machine) destroy(timeout time.Duration) error {
+	unregisterMachine(m)
+	destroyCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	startSpinner(fmt.Sprintf("Running terraform destroy (timeout: %v)...", timeout))
+
+	// Capture stderr/stdout for debugging
+	destroyBuffer := &bytes.Buffer{}
+	m.terraform.SetStdout(destroyBuffer)
+	m.terraform.SetStderr(destroyBuffer)
+
+	var destroyVars []tfexec.DestroyOption
+	for _, v := range m.vars {
+		destroyVars = append(destroyVars, tfexec.Var(v))
+	}
+	destroyErr := m.terraform.Destroy(destroyCtx, destroyVars...)
+	stopSpinner()
+
+	if destroyErr != nil {
+		if errors.Is(destroyErr, context.DeadlineExceeded) {
+			errorLog("Terraform destroy timed out after %v. Resources may still exist.", timeout)
+			fmt.Printf("⚠️  Terraform destroy operation timed out. Resources might still exist! Manually destroy with:\n"+
+				"%s\n", destroyCommand(m.dir, m.terraform.ExecPath(), m.vars))
+		} else {
+			errorLog("Error running terraform destroy: %s", destroyErr)
+			fmt.Printf("⚠️  Although, an error occurred while running terraform destroy, resources might have been created! Ensure to run:\n"+
+				"%s\n", destroyCommand(m.dir, m.terraform.ExecPath(), m.vars))
+		}
+
+		// Output buffer content to help diagnose the issue
+		if debugMode {
+			fmt.Println("Debug output from terraform destroy:")
+			fmt.Println(redact(destroyBuffer.String()))
+		}
+		return destroyErr
+	}
+	successLog("Terraform resources destroyed successfully")
+	// The state is of no use once its resources are gone
+	os.RemoveAll(m.dir)
+	return nil
+}
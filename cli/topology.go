@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// serverScriptName is the script starting --server-command on the server
+// machine, staged next to the run script.
+const serverScriptName = "start_server.sh"
+
+// serverEnvFile exports serverIPVariable to the run script of the client. It
+// is written into the staged folder once the machines are provisioned.
+const serverEnvFile = ".ib-server.env"
+
+// serverLogFile receives the output of --server-command on the server.
+const serverLogFile = ".ib-server.log"
+
+// serverIPVariable holds the private IP address of the server on the client.
+const serverIPVariable = "IB_SERVER_IP"
+
+// readinessProbe tells when the server of --server-command accepts
+// connections: once Port accepts TCP connections, or URL answers with a
+// success status.
+type readinessProbe struct {
+	Port int
+	URL  string
+}
+
+// parseReadinessProbe reads --server-ready, a port of the server or an
+// http(s) URL, which can refer to the server as $IB_SERVER_IP.
+func parseReadinessProbe(value string) (*readinessProbe, error) {
+	if port, err := strconv.Atoi(value); err == nil {
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("%d is not a TCP port", port)
+		}
+		return &readinessProbe{Port: port}, nil
+	}
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("%q is neither a port nor an http(s) URL", value)
+	}
+	return &readinessProbe{URL: value}, nil
+}
+
+func (p *readinessProbe) String() string {
+	if p.URL != "" {
+		return p.URL
+	}
+	return fmt.Sprintf("port %d", p.Port)
+}
+
+// condition returns the shell command succeeding once the server is ready.
+func (p *readinessProbe) condition() string {
+	if p.URL != "" {
+		// Double quotes, so that $IB_SERVER_IP expands
+		quoted := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`").Replace(p.URL)
+		return `curl -s -f -o /dev/null -m 5 "` + quoted + `"`
+	}
+	return fmt.Sprintf(`(exec 3<>"/dev/tcp/$%s/%d") 2>/dev/null`, serverIPVariable, p.Port)
+}
+
+// waitLines returns the lines of the section polling the server until it is
+// ready, failing after timeout.
+func (p *readinessProbe) waitLines(timeout time.Duration) []string {
+	return []string{
+		fmt.Sprintf(`ib_ready_deadline=$(( $(date +%%s) + %d ))`, int(timeout.Seconds())),
+		fmt.Sprintf(`until %s || [ "$(date +%%s)" -ge "$ib_ready_deadline" ]; do sleep 1; done`, p.condition()),
+		fmt.Sprintf(`{ %s || { echo "The server at $%s is not ready after %v (%s)"; false; }; }`, p.condition(), serverIPVariable, timeout, p),
+	}
+}
+
+// buildServerScript renders the script preparing the server machine like
+// the run script does, and starting command in the background. The command
+// keeps running once the script returns, its output going to serverLogFile.
+func buildServerScript(opts runScriptOptions, command string) string {
+	var b strings.Builder
+	opts.Ready = nil
+	writeScriptHeader(&b, opts)
+	if len(opts.Runtimes) > 0 {
+		writeSection(&b, "runtime "+strings.Join(opts.Runtimes, ","), runtimeSetupLines(opts.Runtimes), !opts.Verbose)
+	}
+	if len(opts.Setup) > 0 {
+		writeSection(&b, "setup", opts.Setup, false)
+	}
+	if opts.Stabilize != nil {
+		b.WriteString(strings.Join(stabilizeLines(*opts.Stabilize), "\n") + "\n")
+	}
+	b.WriteString(strings.Join(sysinfoLines(opts.Runtimes), "\n") + "\n")
+
+	// A server exiting right away is reported with the end of its output
+	writeSection(&b, "server", []string{
+		fmt.Sprintf("{ setsid nohup bash -c %s > %s 2>&1 < /dev/null & }", shellQuote(command), serverLogFile),
+		"ib_server_pid=$!",
+		"sleep 1",
+		fmt.Sprintf(`{ kill -0 "$ib_server_pid" 2>/dev/null || { echo "The server exited:"; tail -n 30 %s; false; }; }`, serverLogFile),
+	}, false)
+	return b.String()
+}
+
+// topologyOptions configure the benchmark of --server-command.
+type topologyOptions struct {
+	StagedFolder string
+	RunID        string
+	Collect      []string
+	// ClientType is the instance or server type of the client
+	ClientType string
+}
+
+// runTopology starts --server-command on the server machine of m, then runs
+// the benchmark on its client, which reaches the server on its private IP.
+// The system information of both machines is recorded in result, along with
+// the output of the server. It returns false if the server could not start or
+// the benchmark failed.
+func runTopology(m *machine, opts topologyOptions, result *BenchmarkResult) bool {
+	env := fmt.Sprintf("export %s=%s\n", serverIPVariable, shellQuote(m.privateIP))
+	if err := os.WriteFile(filepath.Join(opts.StagedFolder, serverEnvFile), []byte(env), 0644); err != nil {
+		errorLog("Failed to stage the address of the server: %v", err)
+		return false
+	}
+
+	infoLog("── server ──")
+	startSpinner("Copying files to the server...")
+	err := m.remote.Upload(opts.StagedFolder, remoteBenchmarkDir)
+	stopSpinner()
+	if err != nil {
+		errorLog("Failed to copy files to the server: %v", err)
+		return false
+	}
+	infoLog("Starting the server...")
+	output, err := m.remote.Run("bash " + remoteBenchmarkDir + "/" + serverScriptName)
+	if !reportSections(parseSections(output)) {
+		return false
+	}
+	if err != nil {
+		errorLog("Failed to start the server: %v\nOutput: %s", err, output)
+		return false
+	}
+	server := parseSystemInfo(output)
+	server.Provider = result.System.Provider
+	server.InstanceType = result.System.InstanceType
+	server.Location = result.System.Location
+	printSystemInfo(server)
+	printStabilizeSteps(parseStabilizeSteps(output))
+	successLog("Server started on %s", m.privateIP)
+
+	infoLog("── client ──")
+	result.System.InstanceType = opts.ClientType
+	ok := runBenchmark(m.client, opts.StagedFolder, result)
+	result.Machines = map[string]SystemInfo{"server": server, "client": result.System}
+	result.System = server
+
+	// The output of the server explains failures and shows its own logs
+	infoLog("── server output ──")
+	serverOutput, err := m.remote.Run("cat " + remoteBenchmarkDir + "/" + serverLogFile)
+	if err != nil {
+		errorLog("Failed to read the output of the server: %v", err)
+	} else {
+		result.ServerOutput = redact(string(serverOutput))
+		fmt.Println(result.ServerOutput)
+	}
+	if len(opts.Collect) > 0 {
+		localDir := filepath.Join(resultsDir, opts.RunID, "server")
+		files, err := collectArtifacts(m.remote, remoteBenchmarkDir, opts.Collect, localDir)
+		if err != nil {
+			errorLog("Failed to collect artifacts from the server: %v", err)
+		} else if len(files) > 0 {
+			successLog("Collected %d file(s) from the server into %s", len(files), localDir)
+		}
+	}
+	return ok
+}